@@ -5,8 +5,10 @@ go 1.24.3
 require (
 	github.com/go-gst/go-gst v1.4.0
 	github.com/go-vgo/robotgo v0.110.8
+	github.com/jezek/xgb v1.1.1
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/spf13/cobra v1.10.1
+	golang.org/x/crypto v0.41.0
 	golang.org/x/net v0.43.0
 )
 
@@ -18,7 +20,6 @@ require (
 	github.com/go-ole/go-ole v1.3.0 // indirect
 	github.com/godbus/dbus/v5 v5.1.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/jezek/xgb v1.1.1 // indirect
 	github.com/lufia/plan9stats v0.0.0-20250317134145-8bc96cf8fc35 // indirect
 	github.com/mattn/go-pointer v0.0.1 // indirect
 	github.com/otiai10/gosseract v2.2.1+incompatible // indirect
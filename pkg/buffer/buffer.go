@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/binary"
 	"errors"
+	"io"
 	"net"
 	"reflect"
 	"sync"
@@ -13,22 +14,35 @@ import (
 
 // ReadWriter is a buffer read/writer for RFB connections.
 type ReadWriter struct {
+	c  net.Conn
 	br *bufio.Reader
 	bw *bufio.Writer
 
 	wq        chan []byte
+	upgrades  chan *upgrade
+	done      chan struct{} // closed when the writer goroutine exits
 	closeOnce sync.Once
 	closed    uint32 // 0=open, 1=closed
 }
 
+// upgrade is a request to pause the writer while the transport is swapped.
+type upgrade struct {
+	ready  chan struct{} // closed by the writer once the old transport is flushed
+	resume chan struct{} // closed by Upgrade once the new transport is in place
+}
+
 // NewReadWriteBuffer returns a new ReadWriter for the given connection.
 func NewReadWriteBuffer(c net.Conn) *ReadWriter {
 	rw := &ReadWriter{
-		br: bufio.NewReader(c),
-		bw: bufio.NewWriterSize(c, 256<<10),
-		wq: make(chan []byte, 100),
+		c:        c,
+		br:       bufio.NewReader(c),
+		bw:       bufio.NewWriterSize(c, 256<<10),
+		wq:       make(chan []byte, 100),
+		upgrades: make(chan *upgrade),
+		done:     make(chan struct{}),
 	}
 	go func() {
+		defer close(rw.done)
 		flushTicker := time.NewTicker(8 * time.Millisecond)
 		defer flushTicker.Stop()
 		for {
@@ -42,6 +56,14 @@ func NewReadWriteBuffer(c net.Conn) *ReadWriter {
 					rw.Close()
 					return
 				}
+			case u := <-rw.upgrades:
+				// Everything dispatched before the upgrade must go out on the old transport.
+				if err := rw.drain(); err != nil {
+					rw.Close()
+					return
+				}
+				close(u.ready)
+				<-u.resume
 			case <-flushTicker.C:
 				if err := rw.flush(); err != nil {
 					rw.Close()
@@ -53,6 +75,55 @@ func NewReadWriteBuffer(c net.Conn) *ReadWriter {
 	return rw
 }
 
+// Upgrade replaces the transport underneath the buffer with the connection returned by fn.
+// Messages already dispatched are flushed on the old transport first, and fn is handed a
+// connection that still yields any bytes the buffer had read ahead. This is used by security
+// types that wrap the session (e.g. in TLS) part way through the handshake.
+func (rw *ReadWriter) Upgrade(fn func(net.Conn) (net.Conn, error)) error {
+	u := &upgrade{ready: make(chan struct{}), resume: make(chan struct{})}
+	select {
+	case rw.upgrades <- u:
+	case <-rw.done:
+		return errors.New("buffer is closed")
+	}
+	<-u.ready
+	defer close(u.resume)
+
+	c, err := fn(&bufferedConn{Conn: rw.c, r: rw.br})
+	if err != nil {
+		return err
+	}
+	rw.c = c
+	rw.br = bufio.NewReader(c)
+	rw.bw = bufio.NewWriterSize(c, 256<<10)
+	return nil
+}
+
+// drain writes out any queued messages and flushes the write buffer.
+func (rw *ReadWriter) drain() error {
+	for {
+		select {
+		case msg, ok := <-rw.wq:
+			if !ok {
+				return rw.flush()
+			}
+			if err := rw.write(msg); err != nil {
+				return err
+			}
+		default:
+			return rw.flush()
+		}
+	}
+}
+
+// bufferedConn is a net.Conn that reads through a reader holding read-ahead data.
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
 // Close will stop this buffer from processing messages.
 func (rw *ReadWriter) Close() {
 	rw.closeOnce.Do(func() {
@@ -63,6 +134,9 @@ func (rw *ReadWriter) Close() {
 
 func (rw *ReadWriter) IsClosed() bool { return atomic.LoadUint32(&rw.closed) == 1 }
 
+// Done returns a channel that is closed once everything queued before Close has been written.
+func (rw *ReadWriter) Done() <-chan struct{} { return rw.done }
+
 // Reader returns a direct reference to the underlying reader.
 func (rw *ReadWriter) Reader() *bufio.Reader { return rw.br }
 
@@ -142,5 +216,18 @@ func (rw *ReadWriter) DispatchLatest(msg []byte) {
 	}
 }
 
+// DispatchWait queues a message, waiting for room rather than dropping anything. It is
+// used for framebuffer updates, which stateful encodings need delivered in full and in order.
+func (rw *ReadWriter) DispatchWait(msg []byte) {
+	if rw.IsClosed() {
+		return
+	}
+	defer func() { _ = recover() }()
+	select {
+	case rw.wq <- msg:
+	case <-rw.done:
+	}
+}
+
 // Pending returns approximate queued messages (for pacing).
 func (rw *ReadWriter) Pending() int { return len(rw.wq) }
@@ -2,21 +2,24 @@ package cli
 
 import (
 	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/go-gst/go-gst/gst"
 	"github.com/go-vgo/robotgo"
 	"github.com/spf13/cobra"
 
 	"github.com/kamrankamilli/gsvnc/pkg/config"
+	"github.com/kamrankamilli/gsvnc/pkg/display"
 	"github.com/kamrankamilli/gsvnc/pkg/display/providers"
 	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
 	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
@@ -36,6 +39,25 @@ var websockifyHost string
 var websockifyPort int32
 var noTCP bool
 var serverPasswordFile string
+var viewOnlyPasswordFile string
+var tlsCertFile string
+var tlsKeyFile string
+var vencryptSubtypes string
+var rsaKeyFile string
+var htpasswdFile string
+var authFailureThreshold int
+var authBlacklistTimeout time.Duration
+var authFailureDelay time.Duration
+var allowNets []string
+var denyNets []string
+var trustedProxies []string
+var accessRulesFile string
+var tokenKeyFile string
+var requireToken bool
+var clipboardMaxText int
+var clipboardMaxRTF int
+var clipboardMaxHTML int
+var clipboardDirection string
 
 // RootCmd is the exported root cmd for the gsvnc server.
 var RootCmd = &cobra.Command{
@@ -65,7 +87,26 @@ func init() {
 	RootCmd.PersistentFlags().StringVarP(&bindHost, "host", "H", "127.0.0.1", "The host address to bind the server to.")
 	RootCmd.PersistentFlags().Int32VarP(&bindPort, "port", "p", 5900, "The port to bind the server to.")
 	RootCmd.PersistentFlags().StringVarP(&initialResolution, "resolution", "r", "", "The initial resolution to set for display connections. Defaults to auto-detect.")
-	RootCmd.PersistentFlags().StringVarP(&serverPasswordFile, "password-file", "", "", "A file to read in a server password from. One will be generated if this is omitted.")
+	RootCmd.PersistentFlags().StringVarP(&serverPasswordFile, "password-file", "", "", "A file to read in a server password from, in plain text or vncpasswd format. One will be generated if this is omitted. It is re-read when it changes or on SIGHUP.")
+	RootCmd.PersistentFlags().StringVarP(&viewOnlyPasswordFile, "view-only-password-file", "", "", "A file to read in a second password from, which only grants view-only access.")
+	RootCmd.PersistentFlags().StringVarP(&tlsCertFile, "tls-cert", "", "", "A PEM certificate to present to VeNCrypt clients. A self-signed one is generated if omitted.")
+	RootCmd.PersistentFlags().StringVarP(&tlsKeyFile, "tls-key", "", "", "The PEM private key for --tls-cert.")
+	RootCmd.PersistentFlags().StringVarP(&vencryptSubtypes, "vencrypt-subtypes", "", "", "A comma-separated list of VeNCrypt subtypes to offer, in order of preference. Defaults to all available.")
+	RootCmd.PersistentFlags().StringVarP(&rsaKeyFile, "rsa-key", "", defaultRSAKeyFile(), "A PEM RSA private key for the RSA-AES security types. It is generated if it does not exist.")
+	RootCmd.PersistentFlags().StringVarP(&htpasswdFile, "htpasswd", "", "", "An htpasswd file of bcrypt hashes (htpasswd -B) for username/password logins.")
+	RootCmd.PersistentFlags().IntVarP(&authFailureThreshold, "auth-failure-threshold", "", rfb.DefaultAuthFailureThreshold, "Failed authentications from one address before it is blacklisted. Negative disables blacklisting.")
+	RootCmd.PersistentFlags().DurationVarP(&authBlacklistTimeout, "auth-blacklist-timeout", "", rfb.DefaultAuthBlacklistTimeout, "How long an address is first blacklisted for. Doubles for repeat offenders.")
+	RootCmd.PersistentFlags().DurationVarP(&authFailureDelay, "auth-failure-delay", "", rfb.DefaultAuthFailureDelay, "Delay before answering a failed authentication, doubled per consecutive failure. Negative disables it.")
+	RootCmd.PersistentFlags().StringSliceVarP(&allowNets, "allow", "", nil, "Only allow clients from these CIDRs (repeatable or comma-separated).")
+	RootCmd.PersistentFlags().StringSliceVarP(&denyNets, "deny", "", nil, "Never allow clients from these CIDRs. Takes precedence over --allow.")
+	RootCmd.PersistentFlags().StringSliceVarP(&trustedProxies, "trusted-proxy", "", nil, "Proxies whose X-Forwarded-For header is trusted for websocket connections.")
+	RootCmd.PersistentFlags().StringVarP(&accessRulesFile, "access-file", "", "", "A file of allow/deny/trust-proxy rules, added to the flags and re-read on SIGHUP.")
+	RootCmd.PersistentFlags().StringVarP(&tokenKeyFile, "token-key-file", "", "", "A file holding the HMAC key for single-use HS256 tokens, which websocket clients may pass as ?token= instead of authenticating.")
+	RootCmd.PersistentFlags().BoolVarP(&requireToken, "require-token", "", false, "Reject websocket clients that do not pass a token.")
+	RootCmd.PersistentFlags().StringVarP(&clipboardDirection, "clipboard", "", "both", "Which way to share the clipboard: both, to-host, to-client or off.")
+	RootCmd.PersistentFlags().IntVarP(&clipboardMaxText, "clipboard-max-text", "", display.DefaultClipboardLimits.Text, "The largest plain text clipboard accepted from clients, in bytes. Negative refuses it.")
+	RootCmd.PersistentFlags().IntVarP(&clipboardMaxRTF, "clipboard-max-rtf", "", display.DefaultClipboardLimits.RTF, "The largest RTF clipboard accepted from extended clipboard clients, in bytes. Negative refuses it.")
+	RootCmd.PersistentFlags().IntVarP(&clipboardMaxHTML, "clipboard-max-html", "", display.DefaultClipboardLimits.HTML, "The largest HTML clipboard accepted from extended clipboard clients, in bytes. Negative refuses it.")
 	RootCmd.PersistentFlags().BoolVarP(&listFeatures, "list-features", "l", false, "List the available features and exit.")
 	RootCmd.PersistentFlags().StringVarP(&displayProvider, "display", "D", providers.ProviderGstreamer, "The display provider to use for RFB connections.")
 	RootCmd.PersistentFlags().BoolVarP(&websockify, "websockify", "w", false, "Start a websockify listener")
@@ -130,31 +171,131 @@ func run(cmd *cobra.Command, args []string) error {
 	log.Info("Enabled encodings: ", enabledEncs)
 	log.Info("Enabled event handlers: ", enabledEvents)
 
+	accessRules, err := loadAccessRules()
+	if err != nil {
+		return err
+	}
+
+	clipboard, err := display.ParseClipboardDirection(clipboardDirection)
+	if err != nil {
+		return err
+	}
+
 	opts := &rfb.ServerOpts{
 		Width: w, Height: h,
 		DisplayProvider:  providers.Provider(displayProvider),
 		EnabledAuthTypes: authTypes,
 		EnabledEncodings: encTypes,
 		EnabledEvents:    eventTypes,
+
+		AuthFailureThreshold: authFailureThreshold,
+		AuthBlacklistTimeout: authBlacklistTimeout,
+		AuthFailureDelay:     authFailureDelay,
+		AccessRules:          accessRules,
+		ClipboardLimits: display.ClipboardLimits{
+			Text: clipboardMaxText,
+			RTF:  clipboardMaxRTF,
+			HTML: clipboardMaxHTML,
+		},
+		ClipboardDirection: clipboard,
+	}
+
+	if htpasswdFile != "" {
+		htpasswd, err := auth.LoadHtpasswd(htpasswdFile)
+		if err != nil {
+			return err
+		}
+		opts.CredentialVerifier = htpasswd
+		log.Info("Using username/password logins from ", htpasswdFile)
+	}
+
+	if authIsEnabled(authTypes, "RA2", "RA2ne", "RA256", "RAne256") {
+		if rsaKeyFile == "" {
+			return errors.New("RSA-AES is enabled but no --rsa-key was given")
+		}
+		if opts.RSAKey, err = auth.LoadOrGenerateRSAKey(rsaKeyFile, 2048); err != nil {
+			return fmt.Errorf("Could not load RSA key: %s", err)
+		}
+		log.Info("Using RSA-AES server key ", rsaKeyFile)
+		log.Info("RSA-AES server key fingerprint: ", auth.RSAKeyFingerprint(&opts.RSAKey.PublicKey))
+	}
+
+	if authIsEnabled(authTypes, "VNCAuth", "ARD", "RA2", "RA2ne", "RA256", "RAne256") {
+		if opts.ServerPassword, opts.ViewOnlyPassword, err = loadPasswords(""); err != nil {
+			return err
+		}
+		if opts.ServerPassword == "" {
+			log.Info("Password authentication is enabled and no password provided, generating a server password")
+			opts.ServerPassword = util.RandomString(8)
+			log.Info("Clients can connect with the following password: ", opts.ServerPassword)
+		}
+	}
+
+	if tokenKeyFile != "" {
+		key, err := os.ReadFile(tokenKeyFile)
+		if err != nil {
+			return err
+		}
+		key = bytes.TrimSpace(key)
+		if len(key) == 0 {
+			return fmt.Errorf("%s is empty", tokenKeyFile)
+		}
+		opts.TokenValidator = &auth.TokenValidator{Key: key}
+		log.Info("Accepting websocket session tokens signed with the key in ", tokenKeyFile)
+	}
+	if requireToken {
+		if opts.TokenValidator == nil {
+			return errors.New("--require-token needs a --token-key-file")
+		}
+		opts.RequireToken = true
 	}
 
-	if authIsEnabled(authTypes, "VNCAuth") {
-		if serverPasswordFile != "" {
-			passw, err := ioutil.ReadFile(serverPasswordFile)
+	if authIsEnabled(authTypes, "VeNCrypt") {
+		if tlsCertFile != "" || tlsKeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile)
 			if err != nil {
+				return fmt.Errorf("Could not load TLS certificate: %s", err)
+			}
+			opts.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		}
+		if vencryptSubtypes != "" {
+			if opts.VeNCryptSubtypes, err = auth.ParseVeNCryptSubtypes(vencryptSubtypes); err != nil {
 				return err
 			}
-			opts.ServerPassword = string(passw)
-		} else {
-			log.Info("VNCAuth is enabled and no password provided, generating a server password")
-			opts.ServerPassword = util.RandomString(8)
-			log.Info("Clients using VNCAuth can connect with the following password: ", opts.ServerPassword)
 		}
 	}
 
 	// Create a new rfb server
 	server := rfb.NewServer(opts)
 
+	var reloaders []func()
+	if opts.ServerPassword != "" && (serverPasswordFile != "" || viewOnlyPasswordFile != "") {
+		fallback := opts.ServerPassword
+		reloadPasswords := func() {
+			password, viewOnly, err := loadPasswords(fallback)
+			if err != nil {
+				log.Error("Could not reload passwords, keeping the previous ones: ", err)
+				return
+			}
+			server.SetPassword(password, viewOnly)
+			log.Info("Reloaded server passwords")
+		}
+		reloaders = append(reloaders, reloadPasswords)
+		watchFiles(reloadPasswords, serverPasswordFile, viewOnlyPasswordFile)
+	}
+	if accessRulesFile != "" {
+		reloaders = append(reloaders, func() {
+			rules, err := loadAccessRules()
+			if err != nil {
+				log.Error("Could not reload access rules, keeping the previous ones: ", err)
+				return
+			}
+			server.SetAccessRules(rules)
+			log.Info("Reloaded access rules from ", accessRulesFile)
+		})
+	}
+	reloadOnSignal(reloaders...)
+
 	if noTCP && !websockify {
 		return errors.New("No listeners configured")
 	}
@@ -178,6 +319,47 @@ func run(cmd *cobra.Command, args []string) error {
 	return server.Serve(l)
 }
 
+// loadPasswords reads the server password from --password-file, falling back to the given
+// one, and the view-only password from --view-only-password-file if one is given. The latter
+// overrides a view-only password stored in a vncpasswd file.
+func loadPasswords(fallback string) (password, viewOnly string, err error) {
+	password = fallback
+	if serverPasswordFile != "" {
+		if password, viewOnly, err = auth.ReadPasswordFile(serverPasswordFile); err != nil {
+			return "", "", err
+		}
+	}
+	if viewOnlyPasswordFile != "" {
+		if viewOnly, _, err = auth.ReadPasswordFile(viewOnlyPasswordFile); err != nil {
+			return "", "", err
+		}
+	}
+	return password, viewOnly, nil
+}
+
+// loadAccessRules combines the access rules given as flags with those in --access-file.
+func loadAccessRules() (*rfb.AccessRules, error) {
+	var rules rfb.AccessRules
+	var err error
+	if rules.Allow, err = rfb.ParsePrefixes(allowNets); err != nil {
+		return nil, fmt.Errorf("Could not parse --allow: %s", err)
+	}
+	if rules.Deny, err = rfb.ParsePrefixes(denyNets); err != nil {
+		return nil, fmt.Errorf("Could not parse --deny: %s", err)
+	}
+	if rules.TrustedProxies, err = rfb.ParsePrefixes(trustedProxies); err != nil {
+		return nil, fmt.Errorf("Could not parse --trusted-proxy: %s", err)
+	}
+	if accessRulesFile == "" {
+		return &rules, nil
+	}
+	fileRules, err := rfb.LoadAccessRules(accessRulesFile)
+	if err != nil {
+		return nil, err
+	}
+	return rules.Merge(fileRules), nil
+}
+
 func serveWebsockify(srvr *rfb.Server) error {
 	wsAddr := fmt.Sprintf("%s:%d", websockifyHost, websockifyPort)
 	l, err := net.Listen("tcp", wsAddr)
@@ -229,19 +411,41 @@ func doListFeatures(authTypes []auth.Type, encTypes []encodings.Encoding, evType
 
 func configureFeatures(args []string) ([]auth.Type, []encodings.Encoding, []events.Event) {
 	return configureAuthTypes(auth.GetDefaults(), args),
-		configureEncodings(encodings.GetDefaults(), args),
+		configureEncodings(defaultEncodings(), args),
 		configureEvents(events.GetDefaults(), args)
 }
 
-func authIsEnabled(tt []auth.Type, name string) bool {
+// defaultEncodings returns the default encodings, with H.264 first when GStreamer has an
+// encoder for it.
+func defaultEncodings() []encodings.Encoding {
+	encs := encodings.GetDefaults()
+	if !providers.H264Available() {
+		log.Debug("No GStreamer H.264 encoder found, H264Encoding is disabled")
+		return encs
+	}
+	return append([]encodings.Encoding{&encodings.H264Encoding{NewEncoder: providers.NewH264Encoder}}, encs...)
+}
+
+func authIsEnabled(tt []auth.Type, names ...string) bool {
 	for _, t := range tt {
-		if reflect.TypeOf(t).Elem().Name() == name {
-			return true
+		for _, name := range names {
+			if reflect.TypeOf(t).Elem().Name() == name {
+				return true
+			}
 		}
 	}
 	return false
 }
 
+// defaultRSAKeyFile returns where the RSA-AES server key is kept when --rsa-key is not given.
+func defaultRSAKeyFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gsvnc", "rsa_key.pem")
+}
+
 func configureAuthTypes(tt []auth.Type, args []string) []auth.Type {
 	for _, arg := range args {
 		if strings.HasPrefix(arg, "-") {
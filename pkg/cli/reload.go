@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
+)
+
+// reloadOnSignal runs the given functions whenever the process receives SIGHUP.
+func reloadOnSignal(fns ...func()) {
+	if len(fns) == 0 {
+		return
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			log.Info("Received SIGHUP, reloading configuration")
+			for _, fn := range fns {
+				fn()
+			}
+		}
+	}()
+}
+
+// fileWatchInterval is how often watched files are checked for changes.
+const fileWatchInterval = 5 * time.Second
+
+// watchFiles calls fn whenever one of the given files changes. Empty paths are ignored.
+func watchFiles(fn func(), paths ...string) {
+	stat := func() []string {
+		out := make([]string, 0, len(paths))
+		for _, path := range paths {
+			if path == "" {
+				continue
+			}
+			if info, err := os.Stat(path); err == nil {
+				out = append(out, fmt.Sprintf("%s %d", info.ModTime(), info.Size()))
+			} else {
+				out = append(out, "")
+			}
+		}
+		return out
+	}
+	go func() {
+		last := stat()
+		for range time.Tick(fileWatchInterval) {
+			current := stat()
+			if !slices.Equal(current, last) {
+				last = current
+				fn()
+			}
+		}
+	}()
+}
@@ -1,7 +1,6 @@
 package display
 
 import (
-	"hash/crc32"
 	"time"
 
 	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
@@ -54,20 +53,38 @@ func (d *Display) handlePointerEvents() {
 func (d *Display) handleFrameBufferEvents() {
 	ticker := time.NewTicker(200 * time.Millisecond)
 	defer ticker.Stop()
+	// Continuous updates are checked for often, then wait for the next frame.
+	continuous := time.NewTicker(continuousUpdateInterval)
+	defer continuous.Stop()
 
 	for {
 		select {
 		case <-d.done:
 			return
 
+		case change := <-d.settingsQueue:
+			change()
+
 		case ur, ok := <-d.fbReqQueue:
 			if !ok {
 				return
 			}
 			log.Debug("Handling framebuffer update request")
+			// The client's changes from before the request apply to the update.
+			d.applySettings()
 			d.pushFrame(ur)
 
+		case r := <-d.resizeQueue:
+			d.applyResize(r)
+
+		case <-continuous.C:
+			d.pushContinuous()
+
 		case <-ticker.C:
+			// Continuous updates already send everything that changes.
+			if d.continuous {
+				continue
+			}
 			// Only push keepalive when writer isn't busy and is open.
 			if d.buf != nil {
 				if d.buf.IsClosed() {
@@ -77,16 +94,37 @@ func (d *Display) handleFrameBufferEvents() {
 					continue
 				}
 			}
-			last := d.GetLastImage()
-			if last != nil {
-				if !frameUnchangedSample(last.Pix, last.Stride, last.Rect.Dx(), last.Rect.Dy(), &d.lastFrameHash) {
-					d.pushImage(last)
-				}
+			if last := d.GetLastImage(); last != nil {
+				d.pushChanges(last, last.Bounds())
 			}
 		}
 	}
 }
 
+// applySettings applies the changes waiting in settingsQueue.
+func (d *Display) applySettings() {
+	for {
+		select {
+		case change := <-d.settingsQueue:
+			change()
+		default:
+			return
+		}
+	}
+}
+
+// applyExtendedClipboard applies the changes waiting in extClipboardQueue.
+func (d *Display) applyExtendedClipboard() {
+	for {
+		select {
+		case ext := <-d.extClipboardQueue:
+			d.setExtendedClipboard(ext)
+		default:
+			return
+		}
+	}
+}
+
 func (d *Display) handleCutTextEvents() {
 	for {
 		select {
@@ -97,7 +135,15 @@ func (d *Display) handleCutTextEvents() {
 				return
 			}
 			log.Debug("Got cut-text event: ", ev)
+			// The client may have changed its encodings before sending it.
+			d.applyExtendedClipboard()
 			d.syncToClipboard(ev)
+
+		case ext := <-d.extClipboardQueue:
+			d.setExtendedClipboard(ext)
+
+		case clip := <-d.clipboardQueue:
+			d.offerClipboard(clip)
 		}
 	}
 }
@@ -108,27 +154,3 @@ func (d *Display) watchChannels() {
 	go d.handleFrameBufferEvents()
 	go d.handleCutTextEvents()
 }
-
-// frameUnchangedSample computes a CRC32 over a subsampled set of pixels to cheaply detect changes.
-// It samples every 8th pixel in both axes to keep cost low.
-func frameUnchangedSample(pix []uint8, stride int, width int, height int, lastHash *uint32) bool {
-	if width <= 0 || height <= 0 {
-		return true
-	}
-	const step = 8
-	tab := crc32.IEEETable
-	var h uint32
-	for y := 0; y < height; y += step {
-		row := y * stride
-		for x := 0; x < width*4; x += step * 4 {
-			if row+x+4 <= len(pix) {
-				h = crc32.Update(h, tab, pix[row+x:row+x+4])
-			}
-		}
-	}
-	if h == *lastHash {
-		return true
-	}
-	*lastHash = h
-	return false
-}
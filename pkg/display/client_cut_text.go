@@ -1,11 +1,86 @@
 package display
 
 import (
-	"github.com/go-vgo/robotgo"
+	"bytes"
+	"strings"
+
+	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
 	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
 )
 
-func (d *Display) syncToClipboard(ev *types.ClientCutText) { robotgo.WriteAll(toUTF8(ev.Text)) }
+func (d *Display) syncToClipboard(ev *types.ClientCutText) {
+	if ev.IsExtended() {
+		d.handleExtendedClipboard(ev.Text)
+		return
+	}
+	if d.canWriteClipboard() {
+		d.clipboard.setFromClient(d, &clipboardData{text: toUTF8(ev.Text)})
+	}
+}
+
+// canWriteClipboard returns true if the client may change the host clipboard.
+func (d *Display) canWriteClipboard() bool { return !d.viewOnly && d.clipboard.direction.ToHost() }
+
+// canReadClipboard returns true if the client may see the host clipboard. Like input, it
+// is kept from view-only clients.
+func (d *Display) canReadClipboard() bool { return !d.viewOnly && d.clipboard.direction.ToClient() }
+
+// dispatchClipboard queues a new host clipboard to offer the client. Only the latest
+// matters, so an older one still waiting is replaced.
+func (d *Display) dispatchClipboard(clip *clipboardData) {
+	for {
+		select {
+		case d.clipboardQueue <- clip:
+			return
+		default:
+		}
+		select {
+		case <-d.clipboardQueue:
+		default:
+		}
+	}
+}
+
+// offerClipboard offers the client a new host clipboard. Extended clipboard clients are
+// told which formats there are, or sent those they take without asking. Others get plain
+// text.
+func (d *Display) offerClipboard(clip *clipboardData) {
+	if !d.canReadClipboard() {
+		return
+	}
+	if !d.extClipboard {
+		d.sendCutText(clip.text)
+		return
+	}
+	formats := clip.formats() & d.clientClipFlags
+	switch {
+	case formats == 0:
+	case d.clientClipFlags&clipboardNotify != 0:
+		d.notifyClipboard(clip)
+	case d.clientClipFlags&clipboardProvide != 0:
+		for f := uint32(1); f&clipboardFormats != 0; f <<= 1 {
+			if limit, ok := d.clientClipLimits[f]; ok && len(clip.get(f)) > limit {
+				formats &^= f
+			}
+		}
+		d.provideClipboard(clip, formats)
+	}
+}
+
+// sendCutText sends the client text as a plain ServerCutText message, in Latin-1 with
+// newlines for line endings.
+func (d *Display) sendCutText(text string) {
+	if d.buf == nil || d.buf.IsClosed() {
+		return
+	}
+	latin1 := fromUTF8(strings.ReplaceAll(text, "\r\n", "\n"))
+	buf := new(bytes.Buffer)
+	util.Write(buf, uint8(cmdServerCutText))
+	util.Write(buf, [3]uint8{}) // padding
+	util.Write(buf, uint32(len(latin1)))
+	buf.Write(latin1)
+	d.buf.DispatchWait(buf.Bytes())
+}
 
 func toUTF8(in []byte) string {
 	// Treat bytes as Latin-1/ASCII fallback
@@ -15,3 +90,15 @@ func toUTF8(in []byte) string {
 	}
 	return string(buf)
 }
+
+// fromUTF8 converts text to Latin-1, replacing what it can't hold with '?'.
+func fromUTF8(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			r = '?'
+		}
+		out = append(out, byte(r))
+	}
+	return out
+}
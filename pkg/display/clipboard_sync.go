@@ -0,0 +1,176 @@
+package display
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-vgo/robotgo"
+	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
+)
+
+// clipboardPollInterval is how often the host clipboard is checked for changes.
+const clipboardPollInterval = 500 * time.Millisecond
+
+// ClipboardDirection says which way the clipboard is shared between the host and clients.
+type ClipboardDirection int
+
+// Clipboard directions. The zero value shares it both ways.
+const (
+	ClipboardBoth ClipboardDirection = iota
+	ClipboardToHost
+	ClipboardToClient
+	ClipboardOff
+)
+
+// ParseClipboardDirection parses "both", "to-host", "to-client" or "off".
+func ParseClipboardDirection(s string) (ClipboardDirection, error) {
+	switch s {
+	case "both":
+		return ClipboardBoth, nil
+	case "to-host":
+		return ClipboardToHost, nil
+	case "to-client":
+		return ClipboardToClient, nil
+	case "off":
+		return ClipboardOff, nil
+	}
+	return 0, fmt.Errorf("unknown clipboard direction %q", s)
+}
+
+// ToHost returns true if clients may change the host clipboard.
+func (c ClipboardDirection) ToHost() bool { return c == ClipboardBoth || c == ClipboardToHost }
+
+// ToClient returns true if clients may read the host clipboard.
+func (c ClipboardDirection) ToClient() bool { return c == ClipboardBoth || c == ClipboardToClient }
+
+// ClipboardSync shares the host clipboard with clients. While any are connected it watches
+// the host clipboard, and offers changes to every client allowed to read it. A client's
+// clipboard goes to the host and straight on to the other clients, and is remembered as
+// what the host has so it doesn't come back as a change.
+type ClipboardSync struct {
+	direction ClipboardDirection
+
+	mu       sync.Mutex
+	displays map[*Display]struct{}
+	stop     chan struct{}
+	// last is what the host clipboard was last seen to hold. The host only takes plain text,
+	// so any other formats a client gave are kept with it while the text stays the same.
+	last *clipboardData
+}
+
+// NewClipboardSync returns a ClipboardSync sharing the clipboard in the given direction.
+func NewClipboardSync(direction ClipboardDirection) *ClipboardSync {
+	return &ClipboardSync{
+		direction: direction,
+		displays:  make(map[*Display]struct{}),
+		last:      &clipboardData{},
+	}
+}
+
+// add starts offering clipboard changes to d, watching the host if it is the first.
+func (c *ClipboardSync) add(d *Display) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.displays[d] = struct{}{}
+	if len(c.displays) == 1 && c.direction.ToClient() {
+		c.stop = make(chan struct{})
+		go c.watch(c.stop)
+	}
+}
+
+// remove stops offering clipboard changes to d, and stops watching if it was the last.
+func (c *ClipboardSync) remove(d *Display) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.displays[d]; !ok {
+		return
+	}
+	delete(c.displays, d)
+	if len(c.displays) == 0 && c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+}
+
+// watch polls the host clipboard until stop is closed. What is there to begin with isn't a
+// change, so it isn't offered.
+func (c *ClipboardSync) watch(stop chan struct{}) {
+	ticker := time.NewTicker(clipboardPollInterval)
+	defer ticker.Stop()
+	c.poll(false)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.poll(true)
+		}
+	}
+}
+
+// poll reads the host clipboard and records it if it changed, offering the change to every
+// client if offer is set. The lock is held while reading, so a client's clipboard can't be
+// written in between and the read be taken for a change back to what was there before.
+func (c *ClipboardSync) poll(offer bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	text, err := robotgo.ReadAll()
+	if err != nil {
+		log.Debug("Could not read clipboard: ", err)
+		return
+	}
+	if text == c.last.text {
+		return
+	}
+	c.last = &clipboardData{text: text}
+	if offer {
+		c.offer(c.last, nil)
+	}
+}
+
+// setFromClient puts clip from the client of d on the host clipboard, and offers it to the
+// other clients.
+func (c *ClipboardSync) setFromClient(d *Display, clip *clipboardData) {
+	if !c.direction.ToHost() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// last is set before the host has the text, so that watch can't see it there first and
+	// offer it back to d as a change.
+	prev := c.last
+	c.last = clip
+	if err := robotgo.WriteAll(clip.text); err != nil {
+		log.Error("Could not write clipboard: ", err)
+		c.last = prev
+		return
+	}
+	if c.direction.ToClient() {
+		c.offer(clip, d)
+	}
+}
+
+// offer hands clip to every display but from. c.mu must be held.
+func (c *ClipboardSync) offer(clip *clipboardData, from *Display) {
+	for d := range c.displays {
+		if d != from {
+			d.dispatchClipboard(clip)
+		}
+	}
+}
+
+// current returns what is on the host clipboard, in every format we have it in.
+func (c *ClipboardSync) current() *clipboardData {
+	text, err := robotgo.ReadAll()
+	if err != nil {
+		log.Debug("Could not read clipboard: ", err)
+		return &clipboardData{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last.text == text {
+		return c.last
+	}
+	return &clipboardData{text: text}
+}
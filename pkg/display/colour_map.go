@@ -0,0 +1,29 @@
+package display
+
+import (
+	"bytes"
+
+	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/encodings"
+)
+
+const cmdSetColourMapEntries = 1
+
+// sendColourMap sends the client the colour map that colour-mapped pixels index into. It
+// has to arrive before any update in the new format, so it is sent from the framebuffer
+// goroutine and waits for room in the queue.
+func (d *Display) sendColourMap() {
+	if d.buf == nil {
+		return
+	}
+	colours := encodings.ColourMap()
+	buf := new(bytes.Buffer)
+	util.Write(buf, uint8(cmdSetColourMapEntries))
+	util.Write(buf, uint8(0))  // padding
+	util.Write(buf, uint16(0)) // first colour
+	util.Write(buf, uint16(len(colours)))
+	for _, c := range colours {
+		util.Write(buf, c)
+	}
+	d.buf.DispatchWait(buf.Bytes())
+}
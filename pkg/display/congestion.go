@@ -0,0 +1,125 @@
+package display
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// minCongestionWindow is the least data allowed in flight, and all that is until the
+	// first round trip has been measured.
+	minCongestionWindow = 64 << 10
+	// congestionGain is how many bandwidth-delay products may be in flight. The headroom
+	// lets the connection deliver faster than the estimate, so the estimate can grow.
+	congestionGain = 2
+	// bandwidthDecay is how much of the bandwidth estimate is kept with each new sample, so
+	// that it follows the connection getting slower.
+	bandwidthDecay = 0.95
+	// rttLifetime is how long the lowest round trip is trusted before it is measured again,
+	// in case the route changed.
+	rttLifetime = 10 * time.Second
+	// maxPings is the most unanswered pings. Once there are that many, updates go without
+	// one until some are answered.
+	maxPings = 16
+)
+
+// congestion limits how much data is sent ahead of what the client has received, like
+// TigerVNC does. Pings sent as fences after updates measure the round trip time and how
+// fast the connection delivers, and updates wait while more than the bandwidth-delay
+// product is in flight.
+type congestion struct {
+	mu sync.Mutex
+	// sent counts the bytes of all updates. acked is how many of them the client is known
+	// to have, as of ackedAt, when sentAtAck had been sent.
+	sent      uint64
+	acked     uint64
+	ackedAt   time.Time
+	sentAtAck uint64
+	pings     []ping
+	nextPing  uint32
+
+	minRTT    time.Duration
+	minRTTAt  time.Time
+	bandwidth float64 // bytes per second
+}
+
+// ping is a fence sent when sent bytes had gone out.
+type ping struct {
+	id   uint32
+	sent uint64
+	at   time.Time
+}
+
+// sentUpdate records an update of n bytes going out.
+func (c *congestion) sentUpdate(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent += uint64(n)
+}
+
+// ping returns the id for a new ping, or false if too many are unanswered.
+func (c *congestion) ping() (uint32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pings) >= maxPings {
+		return 0, false
+	}
+	c.nextPing++
+	c.pings = append(c.pings, ping{id: c.nextPing, sent: c.sent, at: time.Now()})
+	return c.nextPing, true
+}
+
+// pong records the client answering the ping with id, and everything sent before it.
+func (c *congestion) pong(id uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := 0
+	for i < len(c.pings) && c.pings[i].id != id {
+		i++
+	}
+	if i == len(c.pings) {
+		return
+	}
+	p := c.pings[i]
+	// Answers come in order, so any earlier pings have been overtaken.
+	c.pings = c.pings[i+1:]
+
+	now := time.Now()
+	rtt := now.Sub(p.at)
+	if c.minRTT == 0 || rtt < c.minRTT || now.Sub(c.minRTTAt) > rttLifetime {
+		c.minRTT, c.minRTTAt = rtt, now
+	}
+
+	if delivered := p.sent - c.acked; delivered > 0 {
+		// If the data was already sent at the last answer, the connection was busy all
+		// along and delivered it in the time since. Otherwise it was idle for part of that
+		// time, and the data took at least a round trip.
+		interval := rtt
+		if !c.ackedAt.IsZero() && p.sent <= c.sentAtAck {
+			interval = now.Sub(c.ackedAt)
+		}
+		if interval > 0 {
+			sample := float64(delivered) / interval.Seconds()
+			c.bandwidth = max(sample, c.bandwidth*bandwidthDecay)
+		}
+	}
+	c.acked, c.ackedAt, c.sentAtAck = p.sent, now, c.sent
+}
+
+// unpinged reports whether data is in flight with no ping to say when it arrives.
+func (c *congestion) unpinged() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pings) == 0 && c.sent > c.acked
+}
+
+// congested reports whether there is already as much in flight as the connection holds.
+func (c *congestion) congested() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	window := float64(minCongestionWindow)
+	if c.minRTT > 0 {
+		window = max(window, congestionGain*c.bandwidth*c.minRTT.Seconds())
+	}
+	return float64(c.sent-c.acked) > window
+}
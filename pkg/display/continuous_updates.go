@@ -0,0 +1,126 @@
+package display
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"slices"
+	"time"
+
+	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
+	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+const (
+	encodingContinuousUpdates = -313
+	encodingFence             = -312
+
+	cmdEndOfContinuousUpdates = 150
+	cmdFence                  = 248
+
+	// continuousUpdateInterval is how often to look for a frame to send while continuous
+	// updates are on. Frames come slower than this, so updates go out as each one arrives.
+	continuousUpdateInterval = 10 * time.Millisecond
+)
+
+// setContinuousEncodings records whether the client supports continuous updates and fences.
+// Support for each is confirmed when the client first asks for it, with an
+// EndOfContinuousUpdates message and a fence of our own. It runs on the framebuffer
+// goroutine, like everything that reads them.
+func (d *Display) setContinuousEncodings(encs []int32) {
+	if slices.Contains(encs, encodingContinuousUpdates) && !d.continuousOK {
+		d.continuousOK = true
+		d.sendEndOfContinuousUpdates()
+	}
+	if slices.Contains(encs, encodingFence) && !d.fence {
+		d.fence = true
+		d.sendPing()
+	}
+}
+
+// EnableContinuousUpdates turns continuous updates of an area on or off. While on, changes
+// are sent as soon as they appear, as fast as the connection takes them. The change is made
+// on the framebuffer goroutine, so it never lands in the middle of an update.
+func (d *Display) EnableContinuousUpdates(req *types.EnableContinuousUpdates) {
+	d.queueSettings(func() {
+		if !d.continuousOK {
+			log.Warning("Ignoring continuous updates from a client that didn't ask for them")
+			return
+		}
+		if !req.Enabled() {
+			d.continuous = false
+			// The client needs to know no more updates are coming unasked.
+			d.sendEndOfContinuousUpdates()
+			return
+		}
+		d.continuousRect = image.Rect(int(req.X), int(req.Y), int(req.X)+int(req.Width), int(req.Y)+int(req.Height))
+		d.continuous = true
+	})
+}
+
+// pushContinuous sends the changes in the next frame if continuous updates are on and the
+// connection has room for them.
+func (d *Display) pushContinuous() {
+	if !d.continuous || d.buf == nil || d.buf.IsClosed() {
+		return
+	}
+	// Without fences, updates still waiting to be written are the only sign of congestion.
+	if d.buf.Pending() > 0 {
+		return
+	}
+	if d.fence && d.congestion.congested() {
+		if d.congestion.unpinged() {
+			d.sendPing()
+		}
+		return
+	}
+	if img := d.GetLastImage(); img != nil {
+		d.pushChanges(img, d.continuousRect)
+	}
+}
+
+// Fence answers a fence from the client, or records the answer to one of ours.
+func (d *Display) Fence(f *types.Fence) {
+	if !f.IsRequest() {
+		if len(f.Payload) == 4 {
+			d.congestion.pong(binary.BigEndian.Uint32(f.Payload))
+		}
+		return
+	}
+	// Messages are handled in order and updates are written in order, so blocking before
+	// and after comes for free once the answer waits behind the client's earlier changes.
+	// Syncing the next message isn't supported.
+	flags := f.Flags & (types.FenceBlockBefore | types.FenceBlockAfter)
+	d.queueSettings(func() { d.sendFence(flags, f.Payload) })
+}
+
+// sendPing sends a fence for the client to answer once it has everything sent before it.
+func (d *Display) sendPing() {
+	id, ok := d.congestion.ping()
+	if !ok {
+		return
+	}
+	payload := binary.BigEndian.AppendUint32(nil, id)
+	d.sendFence(types.FenceRequest|types.FenceBlockBefore, payload)
+}
+
+func (d *Display) sendFence(flags uint32, payload []byte) {
+	if d.buf == nil || d.buf.IsClosed() {
+		return
+	}
+	buf := new(bytes.Buffer)
+	util.Write(buf, uint8(cmdFence))
+	util.Write(buf, [3]uint8{}) // padding
+	util.Write(buf, flags)
+	util.Write(buf, uint8(len(payload)))
+	buf.Write(payload)
+	d.buf.DispatchWait(buf.Bytes())
+}
+
+func (d *Display) sendEndOfContinuousUpdates() {
+	if d.buf == nil || d.buf.IsClosed() {
+		return
+	}
+	d.buf.DispatchWait([]byte{cmdEndOfContinuousUpdates})
+}
@@ -0,0 +1,224 @@
+package display
+
+import (
+	"hash/maphash"
+	"image"
+	"sort"
+)
+
+const (
+	// maxMoveCandidates is the most motion vectors checked against the tiles of a frame.
+	maxMoveCandidates = 4
+	// minScrollVotes is how many rows (or columns) must agree on a scroll before it is tried.
+	minScrollVotes = damageTileSize / 2
+	// maxHashRepeats skips rows that occur more often than this, such as blank ones, since
+	// they match at any offset.
+	maxHashRepeats = 4
+	// Moved blocks are found by looking for short runs of pixels from the new frame in the
+	// old one, within moveSearchRadius of where they are now, from anchorsPerRow places on
+	// each of moveAnchors rows. Each search compares about (2*moveSearchRadius)^2 runs, so
+	// no more than maxAnchorSearches are made for a frame.
+	moveAnchorWidth   = 16
+	moveAnchors       = 4
+	anchorsPerRow     = 3
+	moveSearchRadius  = 128
+	maxAnchorMatches  = 4
+	maxAnchorSearches = moveAnchors * anchorsPerRow
+	minMoveSearchArea = 4 * damageTileSize * damageTileSize
+)
+
+// copyRect is an area the client copies from elsewhere on its screen.
+type copyRect struct {
+	r   image.Rectangle
+	src image.Point
+}
+
+// findCopies finds areas of img within clip that the client already has elsewhere on its
+// screen, such as after a scroll or a window being moved. The copies are recorded as sent,
+// in the order the client must apply them.
+func (t *damageTracker) findCopies(img *image.RGBA, clip image.Rectangle) []copyRect {
+	if t.sent == nil || t.sent.Bounds() != img.Bounds() {
+		return nil
+	}
+	var copies []copyRect
+	anchors := maxAnchorSearches
+	for _, r := range t.damage(img, clip) {
+		for _, d := range t.moveCandidates(img, r, &anchors) {
+			bounds := t.sent.Bounds()
+			tiles := tileRuns(r, func(tile image.Rectangle) bool {
+				src := tile.Sub(d)
+				return src.In(bounds) && !t.sentEqual(img, tile, tile.Min) && t.sentEqual(img, tile, src.Min)
+			})
+			for _, tile := range tiles {
+				// Earlier copies may have changed the source, so check it again.
+				src := tile.Min.Sub(d)
+				if !t.sentEqual(img, tile, src) {
+					continue
+				}
+				t.markCopied(tile, src)
+				copies = append(copies, copyRect{r: tile, src: src})
+			}
+		}
+	}
+	return copies
+}
+
+// moveCandidates returns the likeliest ways the content of r moved between what the client
+// has and img, as the offset from the old position to the new one. Scrolls are cheap to
+// find, so moved blocks are only searched for when there are none, using up to anchors
+// of the searches left for the frame.
+func (t *damageTracker) moveCandidates(img *image.RGBA, r image.Rectangle, anchors *int) []image.Point {
+	votes := map[image.Point]int{}
+	if dy, n := scrollOffset(rowHashes(t.sent, r), rowHashes(img, r)); n >= minScrollVotes {
+		votes[image.Pt(0, dy)] = n
+	}
+	if dx, n := scrollOffset(colHashes(t.sent, r), colHashes(img, r)); n >= minScrollVotes {
+		votes[image.Pt(dx, 0)] = n
+	}
+	if len(votes) == 0 && area(r) >= minMoveSearchArea {
+		for d, n := range t.anchorMoves(img, r, anchors) {
+			votes[d] += n
+		}
+	}
+	out := make([]image.Point, 0, len(votes))
+	for d := range votes {
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return votes[out[i]] > votes[out[j]] })
+	if len(out) > maxMoveCandidates {
+		out = out[:maxMoveCandidates]
+	}
+	return out
+}
+
+// scrollOffset finds the most common non-zero offset from lines in old to identical lines in
+// cur, and how many lines agree on it.
+func scrollOffset(old, cur []uint64) (offset, votes int) {
+	at := make(map[uint64][]int, len(old))
+	for i, h := range old {
+		at[h] = append(at[h], i)
+	}
+	counts := map[int]int{}
+	for i, h := range cur {
+		if len(at[h]) > maxHashRepeats {
+			continue
+		}
+		for _, j := range at[h] {
+			if j != i {
+				counts[i-j]++
+			}
+		}
+	}
+	for d, n := range counts {
+		if n > votes || (n == votes && abs(d) < abs(offset)) {
+			offset, votes = d, n
+		}
+	}
+	return offset, votes
+}
+
+// rowSeed seeds row hashes, which are only compared within the process.
+var rowSeed = maphash.MakeSeed()
+
+// rowHashes hashes each row of img within r.
+func rowHashes(img *image.RGBA, r image.Rectangle) []uint64 {
+	out := make([]uint64, r.Dy())
+	for y := range out {
+		off := img.PixOffset(r.Min.X, r.Min.Y+y)
+		out[y] = maphash.Bytes(rowSeed, img.Pix[off:off+r.Dx()*4])
+	}
+	return out
+}
+
+// colHashes hashes each column of img within r.
+func colHashes(img *image.RGBA, r image.Rectangle) []uint64 {
+	const prime = 1099511628211
+	out := make([]uint64, r.Dx())
+	for x := range out {
+		out[x] = 14695981039346656037
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		off := img.PixOffset(r.Min.X, y)
+		for x := range out {
+			p := img.Pix[off+x*4 : off+x*4+4]
+			out[x] = (out[x] ^ uint64(p[0]) ^ uint64(p[1])<<8 ^ uint64(p[2])<<16) * prime
+		}
+	}
+	return out
+}
+
+// anchorMoves looks for a few distinctive runs of pixels from img within r in what the
+// client has nearby, and counts the offsets they were found at. Each run searched for
+// uses up one of anchors.
+func (t *damageTracker) anchorMoves(img *image.RGBA, r image.Rectangle, anchors *int) map[image.Point]int {
+	votes := map[image.Point]int{}
+	if r.Dx() < moveAnchorWidth {
+		return votes
+	}
+	n := moveAnchorWidth * 4
+	search := r.Inset(-moveSearchRadius).Intersect(t.sent.Bounds())
+	for i := 1; i <= moveAnchors; i++ {
+		y := r.Min.Y + i*r.Dy()/(moveAnchors+1)
+		for _, x := range t.findAnchors(img, image.Rect(r.Min.X, y, r.Max.X, y+1)) {
+			if *anchors <= 0 {
+				return votes
+			}
+			*anchors--
+			anchor := img.Pix[img.PixOffset(x, y):][:n]
+			var found []image.Point
+			for sy := max(search.Min.Y, y-moveSearchRadius); sy < min(search.Max.Y, y+moveSearchRadius+1); sy++ {
+				for sx := max(search.Min.X, x-moveSearchRadius); sx < min(search.Max.X-moveAnchorWidth, x+moveSearchRadius)+1; sx++ {
+					off := t.sent.PixOffset(sx, sy)
+					if string(t.sent.Pix[off:off+n]) == string(anchor) {
+						found = append(found, image.Pt(x-sx, y-sy))
+					}
+				}
+			}
+			// Anchors found in many places are as likely to be a pattern as the thing that moved.
+			if len(found) > maxAnchorMatches {
+				continue
+			}
+			for _, d := range found {
+				if d != (image.Point{}) {
+					votes[d]++
+				}
+			}
+		}
+	}
+	return votes
+}
+
+// findAnchors returns the starts of a few runs of pixels from the row r of img that changed
+// and aren't a single colour. They are taken from the middle of the changes, away from the
+// edges of whatever moved.
+func (t *damageTracker) findAnchors(img *image.RGBA, r image.Rectangle) []int {
+	n := moveAnchorWidth * 4
+	var runs []int
+	for x := r.Min.X; x+moveAnchorWidth <= r.Max.X; x += moveAnchorWidth {
+		run := img.Pix[img.PixOffset(x, r.Min.Y):][:n]
+		if string(run) == string(t.sent.Pix[t.sent.PixOffset(x, r.Min.Y):][:n]) {
+			continue
+		}
+		for i := 4; i < len(run); i += 4 {
+			if string(run[i:i+4]) != string(run[:4]) {
+				runs = append(runs, x)
+				break
+			}
+		}
+	}
+	if len(runs) <= anchorsPerRow {
+		return runs
+	}
+	out := make([]int, anchorsPerRow)
+	for i := range out {
+		out[i] = runs[(i+1)*len(runs)/(anchorsPerRow+1)]
+	}
+	return out
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,130 @@
+package display
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// testScreen returns a screen of the given size filled with distinct rows and columns, as
+// text and window contents are, so each part of it is only found in one place.
+func testScreen(width, height, seed int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			h := uint32(x)*2654435761 ^ uint32(y+seed)*40503
+			h ^= h >> 15
+			h *= 2246822519
+			img.SetRGBA(x, y, color.RGBA{uint8(h), uint8(h >> 8), uint8(h >> 16), 255})
+		}
+	}
+	return img
+}
+
+// scrolled returns img scrolled up by dy pixels, with new content coming in at the bottom.
+func scrolled(img *image.RGBA, dy int) *image.RGBA {
+	b := img.Bounds()
+	out := testScreen(b.Dx(), b.Dy(), 1<<20)
+	draw.Draw(out, b.Add(image.Pt(0, -dy)), img, b.Min, draw.Src)
+	return out
+}
+
+// withWindow returns a flat screen with a window of distinct content at the given place.
+func withWindow(width, height int, window image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{40, 90, 200, 255}), image.Point{}, draw.Src)
+	draw.Draw(img, window, testScreen(window.Dx(), window.Dy(), 7), image.Point{}, draw.Src)
+	return img
+}
+
+// changedInPlaces returns img with new content in a grid of separate areas, as when
+// several videos play at once.
+func changedInPlaces(img *image.RGBA) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	draw.Draw(out, b, img, b.Min, draw.Src)
+	other := testScreen(b.Dx(), b.Dy(), 12345)
+	for y := 0; y+3*damageTileSize <= b.Dy(); y += 4 * damageTileSize {
+		for x := 0; x+3*damageTileSize <= b.Dx(); x += 4 * damageTileSize {
+			r := image.Rect(x, y, x+3*damageTileSize, y+3*damageTileSize)
+			draw.Draw(out, r, other, r.Min, draw.Src)
+		}
+	}
+	return out
+}
+
+// checkCopies checks every copy brings over what img has in its place, and returns the
+// area copied.
+func checkCopies(t *testing.T, old, img *image.RGBA, copies []copyRect) int {
+	t.Helper()
+	var copied int
+	client := &damageTracker{}
+	client.markSent(old, []image.Rectangle{old.Bounds()})
+	for _, c := range copies {
+		client.markCopied(c.r, c.src)
+		if !client.sentEqual(img, c.r, c.r.Min) {
+			t.Fatalf("copy of %v from %v doesn't match the new frame", c.r, c.src)
+		}
+		copied += area(c.r)
+	}
+	return copied
+}
+
+func TestFindCopies(t *testing.T) {
+	window := image.Rect(200, 150, 520, 390)
+	tests := []struct {
+		name     string
+		old, cur *image.RGBA
+		want     image.Point // the offset of the copies
+		minArea  int
+	}{
+		{name: "scroll", old: testScreen(640, 480, 0), want: image.Pt(0, -40), minArea: 640 * 384},
+		{name: "moved window", old: withWindow(640, 480, window), cur: withWindow(640, 480, window.Add(image.Pt(37, 23))),
+			want: image.Pt(37, 23), minArea: 128 * 128},
+	}
+	tests[0].cur = scrolled(tests[0].old, 40)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tracker damageTracker
+			tracker.markSent(tt.old, []image.Rectangle{tt.old.Bounds()})
+			copies := tracker.findCopies(tt.cur, tt.cur.Bounds())
+			for _, c := range copies {
+				if d := c.r.Min.Sub(c.src); d != tt.want {
+					t.Errorf("copy of %v moved by %v, want %v", c.r, d, tt.want)
+				}
+			}
+			if got := checkCopies(t, tt.old, tt.cur, copies); got < tt.minArea {
+				t.Errorf("copied %d pixels, want at least %d", got, tt.minArea)
+			}
+		})
+	}
+}
+
+// BenchmarkFindCopies measures looking for copies in a 1920x1080 frame. Frames that changed
+// everywhere without moving, such as video, are the most expensive, as every damaged area is
+// searched for moved blocks.
+func BenchmarkFindCopies(b *testing.B) {
+	const width, height = 1920, 1080
+	window := image.Rect(400, 300, 1200, 900)
+	screen := testScreen(width, height, 0)
+	tests := []struct {
+		name     string
+		old, cur *image.RGBA
+	}{
+		{"unchanged", screen, screen},
+		{"scroll", screen, scrolled(screen, 40)},
+		{"moved window", withWindow(width, height, window), withWindow(width, height, window.Add(image.Pt(37, 23)))},
+		{"changed everywhere", screen, testScreen(width, height, 12345)},
+		{"changed in places", screen, changedInPlaces(screen)},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			var tracker damageTracker
+			for b.Loop() {
+				tracker.markSent(tt.old, []image.Rectangle{tt.old.Bounds()})
+				tracker.findCopies(tt.cur, tt.cur.Bounds())
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package display
+
+import (
+	"bytes"
+
+	"github.com/kamrankamilli/gsvnc/pkg/display/providers"
+	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
+	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/encodings"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+const (
+	encodingCursor  = -239
+	encodingXCursor = -240
+)
+
+// setCursorEncoding picks the first cursor pseudo-encoding the client listed, if any, and
+// has the pointer left out of frames for as long as the client draws it itself. It runs on
+// the framebuffer goroutine, which owns the provider it hides the pointer in.
+func (d *Display) setCursorEncoding(encs []int32) {
+	var enc int32
+	for _, e := range encs {
+		if e == encodingCursor || e == encodingXCursor {
+			enc = e
+			break
+		}
+	}
+	if enc != 0 && d.cursorSource == nil {
+		src, err := providers.NewCursorSource()
+		if err != nil {
+			log.Warning("Cursor shapes are unavailable, drawing the pointer into frames: ", err)
+			enc = 0
+		} else {
+			d.cursorSource = src
+		}
+	}
+	d.cursorEncoding = enc
+	// The shape is resent in case the pixel format or cursor encoding changed.
+	d.cursorSent = false
+	if h, ok := d.displayProvider.(providers.PointerHider); ok {
+		h.HidePointer(enc != 0)
+	}
+}
+
+// cursorUpdate returns the cursor to send with the next update, or nil if the client
+// already has it.
+func (d *Display) cursorUpdate() *providers.Cursor {
+	if d.cursorEncoding == 0 {
+		return nil
+	}
+	c, err := d.cursorSource.Cursor()
+	if err != nil {
+		log.Debug("Could not get cursor: ", err)
+		return nil
+	}
+	if d.cursorSent && c.Serial == d.cursorSerial {
+		return nil
+	}
+	return c
+}
+
+// writeCursor writes a rectangle giving the client the cursor's shape.
+func (d *Display) writeCursor(buf *bytes.Buffer, c *providers.Cursor) {
+	b := c.Image.Bounds()
+	util.PackStruct(buf, &types.FrameBufferRectangle{
+		X:       uint16(c.Hotspot.X),
+		Y:       uint16(c.Hotspot.Y),
+		Width:   uint16(b.Dx()),
+		Height:  uint16(b.Dy()),
+		EncType: d.cursorEncoding,
+	})
+	if d.cursorEncoding == encodingXCursor {
+		encodings.WriteXCursor(buf, c.Image)
+	} else {
+		encodings.WriteCursor(buf, d.GetPixelFormat(), c.Image)
+	}
+}
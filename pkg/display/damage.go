@@ -0,0 +1,137 @@
+package display
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+)
+
+const (
+	// damageTileSize is the size of the tiles frames are compared in.
+	damageTileSize = 64
+	// maxDamageRects is the most rectangles an update is split into. Beyond that the
+	// rectangles with the least space between them are merged.
+	maxDamageRects = 16
+	// maxDamageMerge is the number of rectangles above which they are all merged into one
+	// straight away, rather than paying for the careful merge.
+	maxDamageMerge = 4 * maxDamageRects
+)
+
+// damageTracker remembers the picture the client has, so only the parts of a new frame that
+// differ from it need to be sent.
+type damageTracker struct {
+	sent *image.RGBA
+}
+
+// damage returns the areas of img within clip that differ from what the client has, as a
+// bounded set of rectangles.
+func (t *damageTracker) damage(img *image.RGBA, clip image.Rectangle) []image.Rectangle {
+	b := img.Bounds()
+	clip = clip.Intersect(b)
+	if clip.Empty() {
+		return nil
+	}
+	if t.sent == nil || t.sent.Bounds() != b {
+		return []image.Rectangle{clip}
+	}
+
+	return mergeRects(tileRuns(clip, func(tile image.Rectangle) bool {
+		return !t.sentEqual(img, tile, tile.Min)
+	}))
+}
+
+// tileRuns returns the tiles of clip for which keep returns true, merging runs along each
+// row and then identical runs on consecutive rows.
+func tileRuns(clip image.Rectangle, keep func(tile image.Rectangle) bool) []image.Rectangle {
+	var rects []image.Rectangle
+	open := map[[2]int]int{} // x extent of a run on the previous row -> index in rects
+	cols := (clip.Dx() + damageTileSize - 1) / damageTileSize
+	for ty := clip.Min.Y; ty < clip.Max.Y; ty += damageTileSize {
+		next := map[[2]int]int{}
+		runStart := -1
+		for c := 0; c <= cols; c++ {
+			tx := clip.Min.X + c*damageTileSize
+			if c < cols && keep(image.Rect(tx, ty, tx+damageTileSize, ty+damageTileSize).Intersect(clip)) {
+				if runStart < 0 {
+					runStart = tx
+				}
+				continue
+			}
+			if runStart < 0 {
+				continue
+			}
+			run := image.Rect(runStart, ty, tx, ty+damageTileSize).Intersect(clip)
+			key := [2]int{run.Min.X, run.Max.X}
+			if i, ok := open[key]; ok {
+				rects[i].Max.Y = run.Max.Y
+				next[key] = i
+			} else {
+				next[key] = len(rects)
+				rects = append(rects, run)
+			}
+			runStart = -1
+		}
+		open = next
+	}
+	return rects
+}
+
+// sentEqual reports whether the area r of img matches what the client has at src.
+func (t *damageTracker) sentEqual(img *image.RGBA, r image.Rectangle, src image.Point) bool {
+	n := r.Dx() * 4
+	for y := 0; y < r.Dy(); y++ {
+		a := img.PixOffset(r.Min.X, r.Min.Y+y)
+		s := t.sent.PixOffset(src.X, src.Y+y)
+		if !bytes.Equal(img.Pix[a:a+n], t.sent.Pix[s:s+n]) {
+			return false
+		}
+	}
+	return true
+}
+
+// markSent records that the client now has the given areas of img.
+func (t *damageTracker) markSent(img *image.RGBA, rects []image.Rectangle) {
+	if t.sent == nil || t.sent.Bounds() != img.Bounds() {
+		t.sent = image.NewRGBA(img.Bounds())
+	}
+	for _, r := range rects {
+		draw.Draw(t.sent, r, img, r.Min, draw.Src)
+	}
+}
+
+// markCopied records that the client has copied the area at src to r.
+func (t *damageTracker) markCopied(r image.Rectangle, src image.Point) {
+	draw.Draw(t.sent, r, t.sent, src, draw.Src)
+}
+
+// reset forgets what the client has, so the next update covers everything.
+func (t *damageTracker) reset() { t.sent = nil }
+
+// mergeRects merges rectangles until there are at most maxDamageRects, each time merging the
+// pair that adds the least area.
+func mergeRects(rects []image.Rectangle) []image.Rectangle {
+	if len(rects) > maxDamageMerge {
+		var u image.Rectangle
+		for _, r := range rects {
+			u = u.Union(r)
+		}
+		return []image.Rectangle{u}
+	}
+	for len(rects) > maxDamageRects {
+		bi, bj, best := 0, 1, -1
+		for i := range rects {
+			for j := i + 1; j < len(rects); j++ {
+				u := rects[i].Union(rects[j])
+				waste := area(u) - area(rects[i]) - area(rects[j])
+				if best < 0 || waste < best {
+					bi, bj, best = i, j, waste
+				}
+			}
+		}
+		rects[bi] = rects[bi].Union(rects[bj])
+		rects = append(rects[:bj], rects[bj+1:]...)
+	}
+	return rects
+}
+
+func area(r image.Rectangle) int { return r.Dx() * r.Dy() }
@@ -0,0 +1,195 @@
+package display
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+
+	"github.com/kamrankamilli/gsvnc/pkg/display/providers"
+	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
+	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+const (
+	encodingDesktopSize         = -223
+	encodingExtendedDesktopSize = -308
+
+	// maxDesktopSize is the largest width or height a client may ask for.
+	maxDesktopSize = 16384
+)
+
+// Why the desktop size is being sent, in ExtendedDesktopSize rectangles.
+const (
+	desktopSizeServer      = 0
+	desktopSizeClient      = 1
+	desktopSizeOtherClient = 2
+)
+
+// Results of a SetDesktopSize request.
+const (
+	desktopSizeOK          = 0
+	desktopSizeProhibited  = 1
+	desktopSizeNoResources = 2
+	desktopSizeInvalid     = 3
+)
+
+// desktopResize is a desktop size to tell the client about.
+type desktopResize struct {
+	reason, status uint16
+	// resized is set when the host's desktop changed size to width by height, so capture
+	// has to start over. The screen then takes screenID. Otherwise the client is sent the
+	// size it already has.
+	resized       bool
+	width, height int
+	screenID      uint32
+}
+
+// setDesktopSizeEncodings records whether the client can be told the desktop changed size.
+// Clients that support ExtendedDesktopSize are sent the screen layout straight away, which
+// also tells them they may ask for a different size. It runs on the framebuffer goroutine.
+func (d *Display) setDesktopSizeEncodings(encs []int32) {
+	d.desktopSize = slices.Contains(encs, encodingDesktopSize)
+	ext := slices.Contains(encs, encodingExtendedDesktopSize)
+	announce := ext && !d.extDesktopSize
+	d.extDesktopSize = ext
+	if announce {
+		d.applyResize(desktopResize{reason: desktopSizeServer})
+	}
+}
+
+// SetDesktopSize handles a client's request to resize the desktop. If the request fails the
+// client is told why. If it succeeds, every client is told the new size by the server.
+func (d *Display) SetDesktopSize(req *types.SetDesktopSize) {
+	status := d.resizeDesktop(req)
+	if status == desktopSizeOK {
+		return
+	}
+	d.queueResize(desktopResize{reason: desktopSizeClient, status: status})
+}
+
+func (d *Display) resizeDesktop(req *types.SetDesktopSize) uint16 {
+	if d.viewOnly || d.resizeDesktopFunc == nil {
+		return desktopSizeProhibited
+	}
+	if req.Width == 0 || req.Height == 0 || req.Width > maxDesktopSize || req.Height > maxDesktopSize {
+		return desktopSizeInvalid
+	}
+	// The host only has one screen, so that's all a layout can have.
+	if len(req.Screens) != 1 {
+		return desktopSizeInvalid
+	}
+	s := req.Screens[0]
+	if s.Width == 0 || s.Height == 0 ||
+		int(s.X)+int(s.Width) > int(req.Width) || int(s.Y)+int(s.Height) > int(req.Height) {
+		return desktopSizeInvalid
+	}
+	if err := d.resizeDesktopFunc(int(req.Width), int(req.Height), s.ID); err != nil {
+		log.Warning("Could not resize desktop: ", err)
+		if errors.Is(err, providers.ErrResizeUnsupported) {
+			return desktopSizeProhibited
+		}
+		return desktopSizeNoResources
+	}
+	return desktopSizeOK
+}
+
+// DesktopResized tells the display the host's desktop is now width by height, with a screen
+// of the given ID. requested is set when this client asked for it.
+func (d *Display) DesktopResized(width, height int, screenID uint32, requested bool) {
+	reason := uint16(desktopSizeOtherClient)
+	if requested {
+		reason = desktopSizeClient
+	}
+	d.queueResize(desktopResize{width: width, height: height, reason: reason, resized: true, screenID: screenID})
+}
+
+// queueResize hands a desktop size to the framebuffer goroutine, which owns the provider
+// and the order updates go out in. It never blocks, as the server resizes every client's
+// desktop in turn: a size still waiting is merged into the new one instead.
+func (d *Display) queueResize(r desktopResize) {
+	for {
+		select {
+		case d.resizeQueue <- r:
+			return
+		default:
+		}
+		select {
+		case old := <-d.resizeQueue:
+			r = mergeResize(old, r)
+		default:
+		}
+	}
+}
+
+// mergeResize combines a desktop size the framebuffer goroutine hasn't got to with a newer
+// one. The newest size wins, but capture still restarts if either resized the desktop, and
+// a reply to the client's own request isn't dropped for a notification.
+func mergeResize(old, r desktopResize) desktopResize {
+	if !r.resized && old.resized {
+		r.resized, r.width, r.height, r.screenID = true, old.width, old.height, old.screenID
+	}
+	if old.reason == desktopSizeClient && r.reason != desktopSizeClient {
+		r.reason, r.status = old.reason, old.status
+	}
+	return r
+}
+
+// applyResize restarts capture if the host's desktop changed size, following it if the
+// client can be told, then sends the client the size.
+func (d *Display) applyResize(r desktopResize) {
+	select {
+	case <-d.done:
+		return
+	default:
+	}
+	w, h := d.GetDimensions()
+	follow := d.desktopSize || d.extDesktopSize
+	if r.resized {
+		d.screenID = r.screenID
+		if follow {
+			w, h = r.width, r.height
+		}
+		// Even a client that keeps its size needs capture restarted, as the screen it
+		// scales from changed.
+		if err := d.displayProvider.Close(); err != nil {
+			log.Error("Error stopping display for resize: ", err)
+		}
+		d.SetDimensions(w, h)
+		if err := d.displayProvider.Start(w, h); err != nil {
+			log.Error("Error restarting display after resize: ", err)
+		}
+		// Everything has to be sent again at the new size.
+		d.damage.reset()
+	}
+
+	buf := new(bytes.Buffer)
+	util.Write(buf, uint8(cmdFramebufferUpdate))
+	util.Write(buf, uint8(0)) // padding
+	util.Write(buf, uint16(1))
+	switch {
+	case d.extDesktopSize:
+		util.PackStruct(buf, &types.FrameBufferRectangle{
+			X:       r.reason,
+			Y:       r.status,
+			Width:   uint16(w),
+			Height:  uint16(h),
+			EncType: encodingExtendedDesktopSize,
+		})
+		util.Write(buf, uint8(1)) // number of screens
+		util.Write(buf, [3]uint8{})
+		util.Write(buf, types.Screen{ID: d.screenID, Width: uint16(w), Height: uint16(h)})
+	case d.desktopSize && r.resized:
+		util.PackStruct(buf, &types.FrameBufferRectangle{
+			Width:   uint16(w),
+			Height:  uint16(h),
+			EncType: encodingDesktopSize,
+		})
+	default:
+		return
+	}
+	if d.buf == nil || d.buf.IsClosed() {
+		return
+	}
+	d.buf.DispatchWait(buf.Bytes())
+}
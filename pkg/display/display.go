@@ -2,6 +2,7 @@ package display
 
 import (
 	"image"
+	"slices"
 	"sync"
 
 	"github.com/kamrankamilli/gsvnc/pkg/buffer"
@@ -20,6 +21,36 @@ type Display struct {
 	encodings        []int32
 	pseudoEncodings  []int32
 	currentEnc       encodings.Encoding
+	// copyRect is set when CopyRect is enabled on the server and the client asked for it.
+	copyRect        bool
+	copyRectEnabled bool
+	// cursorEncoding is the cursor pseudo-encoding the client draws the pointer with, or 0 if
+	// it is drawn into frames. The shape with cursorSerial was sent when cursorSent is set.
+	cursorEncoding int32
+	cursorSource   providers.CursorSource
+	cursorSerial   uint32
+	cursorSent     bool
+	// desktopSize and extDesktopSize are set when the client can follow the desktop's size.
+	desktopSize    bool
+	extDesktopSize bool
+	// screenID is the ID of the desktop's one screen. It belongs to the framebuffer
+	// goroutine, and changes along with the desktop size.
+	screenID          uint32
+	resizeDesktopFunc func(width, height int, screenID uint32) error
+	// continuousOK and fence are set when the client supports continuous updates and
+	// fences. continuous is set while it has continuous updates of continuousRect on.
+	continuousOK   bool
+	fence          bool
+	continuous     bool
+	continuousRect image.Rectangle
+	congestion     congestion
+	// extClipboard is set when the client supports the extended clipboard, which does what
+	// clientClipFlags says and takes up to clientClipLimits unasked.
+	extClipboard     bool
+	clientClipFlags  uint32
+	clientClipLimits map[uint32]int
+	clipboardLimits  ClipboardLimits
+	clipboard        *ClipboardSync
 
 	// Read/writer for the connected client
 	buf *buffer.ReadWriter
@@ -29,6 +60,15 @@ type Display struct {
 	ptrEvQueue chan *types.PointerEvent
 	keyEvQueue chan *types.KeyEvent
 	cutTxtEvsQ chan *types.ClientCutText
+	// resizeQueue and clipboardQueue are never closed, as other connections send to them.
+	resizeQueue    chan desktopResize
+	clipboardQueue chan *clipboardData
+	// settingsQueue carries changes the client asked for to the framebuffer goroutine,
+	// which owns what updates are sent with. It isn't closed either.
+	settingsQueue chan func()
+	// extClipboardQueue carries whether the client supports the extended clipboard to the
+	// cut-text goroutine, which owns the clipboard state.
+	extClipboardQueue chan bool
 
 	// Memory of keys that are currently down.
 	downKeys []uint32
@@ -37,13 +77,16 @@ type Display struct {
 
 	lastBtnMask uint8
 
+	// viewOnly connections receive updates but their input is dropped.
+	viewOnly bool
+
 	// closed to stop watcher goroutines
 	done chan struct{}
 
 	closeOnce sync.Once
 
-	// lastFrameHash is used to skip sending identical frames on idle screen
-	lastFrameHash uint32
+	// damage tracks what the client has, so updates only cover what changed
+	damage damageTracker
 }
 
 // DefaultPixelFormat used in ServerInit messages (16bpp 5-6-5 true colour).
@@ -67,47 +110,110 @@ type GetEncodingsFunc func(encs []int32) encodings.Encoding
 type Opts struct {
 	DisplayProvider providers.Provider
 	Width, Height   int
+	// ScreenID is the ID of the desktop's screen, as last set by a client.
+	ScreenID        uint32
 	Buffer          *buffer.ReadWriter
 	GetEncodingFunc GetEncodingsFunc
+	// CopyRect enables CopyRect for clients that ask for it.
+	CopyRect bool
+	// ResizeDesktop resizes the host's desktop for a client's SetDesktopSize request, and
+	// tells every client. Requests are refused if it is nil.
+	ResizeDesktop func(width, height int, screenID uint32) error
+	// ClipboardLimits are the largest clipboard contents taken from the client.
+	ClipboardLimits ClipboardLimits
+	// Clipboard shares the host clipboard with other connections. If it is nil, the
+	// clipboard is shared both ways with this connection alone.
+	Clipboard *ClipboardSync
 }
 
 // NewDisplay returns a new display with the given dimensions.
 func NewDisplay(opts *Opts) *Display {
+	clipboard := opts.Clipboard
+	if clipboard == nil {
+		clipboard = NewClipboardSync(ClipboardBoth)
+	}
 	return &Display{
-		displayProvider:  providers.GetDisplayProvider(opts.DisplayProvider),
-		width:            opts.Width,
-		height:           opts.Height,
-		buf:              opts.Buffer,
-		getEncodingsFunc: opts.GetEncodingFunc,
-		pixelFormat:      DefaultPixelFormat,
-		fbReqQueue:       make(chan *types.FrameBufferUpdateRequest, 128),
-		ptrEvQueue:       make(chan *types.PointerEvent, 32),
-		keyEvQueue:       make(chan *types.KeyEvent, 128),
-		cutTxtEvsQ:       make(chan *types.ClientCutText, 128),
-		downKeys:         make([]uint32, 0),
-		done:             make(chan struct{}),
+		displayProvider:   providers.GetDisplayProvider(opts.DisplayProvider),
+		width:             opts.Width,
+		height:            opts.Height,
+		screenID:          opts.ScreenID,
+		buf:               opts.Buffer,
+		getEncodingsFunc:  opts.GetEncodingFunc,
+		copyRectEnabled:   opts.CopyRect,
+		resizeDesktopFunc: opts.ResizeDesktop,
+		clipboardLimits:   opts.ClipboardLimits,
+		clipboard:         clipboard,
+		pixelFormat:       DefaultPixelFormat,
+		fbReqQueue:        make(chan *types.FrameBufferUpdateRequest, 128),
+		settingsQueue:     make(chan func(), 32),
+		ptrEvQueue:        make(chan *types.PointerEvent, 32),
+		keyEvQueue:        make(chan *types.KeyEvent, 128),
+		cutTxtEvsQ:        make(chan *types.ClientCutText, 128),
+		resizeQueue:       make(chan desktopResize, 1),
+		clipboardQueue:    make(chan *clipboardData, 1),
+		extClipboardQueue: make(chan bool, 8),
+		downKeys:          make([]uint32, 0),
+		done:              make(chan struct{}),
+	}
+}
+
+// PseudoEncodings returns the pseudo-encodings a display handles itself, whichever encoding
+// the client picks.
+func PseudoEncodings() []int32 {
+	encs := []int32{
+		encodingDesktopSize,
+		encodingExtendedDesktopSize,
+		encodingContinuousUpdates,
+		encodingFence,
+		encodingExtendedClipboard,
+	}
+	if providers.CursorShapesSupported() {
+		encs = append(encs, encodingCursor, encodingXCursor)
 	}
+	return encs
 }
 
 func (d *Display) GetDimensions() (width, height int) { return d.width, d.height }
 func (d *Display) SetDimensions(width, height int)    { d.width, d.height = width, height }
 func (d *Display) GetPixelFormat() *types.PixelFormat { return d.pixelFormat }
 func (d *Display) SetPixelFormat(pf *types.PixelFormat) {
-	d.pixelFormat = pf
+	d.queueSettings(func() {
+		d.pixelFormat = pf
+		// Cursor pixels are in the client's format, so it needs the shape again.
+		d.cursorSent = false
+		if pf.TrueColour == 0 {
+			d.sendColourMap()
+		}
+	})
 }
-func (d *Display) GetEncodings() []int32 { return d.encodings }
+func (d *Display) IsViewOnly() bool          { return d.viewOnly }
+func (d *Display) SetViewOnly(viewOnly bool) { d.viewOnly = viewOnly }
+func (d *Display) GetEncodings() []int32     { return d.encodings }
 func (d *Display) SetEncodings(encs []int32, pseudoEns []int32) {
-	d.encodings = encs
-	d.pseudoEncodings = pseudoEns
-	d.currentEnc = d.getEncodingsFunc(encs)
+	enc := d.getEncodingsFunc(encs)
+	// Pseudo-encodings are usually listed last, but clients may put them anywhere.
+	all := append(slices.Clone(encs), pseudoEns...)
+	d.queueSettings(func() {
+		d.encodings = encs
+		d.pseudoEncodings = pseudoEns
+		d.currentEnc = enc
+		if c, ok := enc.(encodings.Configurable); ok {
+			c.Configure(encodings.ParseSettings(all))
+		}
+		d.copyRect = d.copyRectEnabled && slices.Contains(all, encodingCopyRect)
+		d.setCursorEncoding(all)
+		d.setDesktopSizeEncodings(all)
+		d.setContinuousEncodings(all)
+	})
+	d.queueExtendedClipboard(all)
 }
 
 func (d *Display) GetCurrentEncoding() encodings.Encoding {
 	if d.currentEnc != nil {
 		return d.currentEnc
 	}
-	// Safe fallback order: Tight -> Raw
-	return encodings.NewTight(encodings.TightOptions{JPEGQuality: 75})
+	// Raw is the only encoding a client that never sent SetEncodings is sure to support.
+	return &encodings.RawEncoding{}
 }
 
 // GetLastImage blocks until a frame is available (or provider closed).
@@ -132,6 +238,15 @@ func (d *Display) DispatchPointerEvent(ev *types.PointerEvent) {
 }
 func (d *Display) DispatchClientCutText(ev *types.ClientCutText) { d.cutTxtEvsQ <- ev }
 
+// queueSettings hands a change the client asked for to the framebuffer goroutine. Changes
+// are applied in the order they were asked for, before any update requested after them.
+func (d *Display) queueSettings(change func()) {
+	select {
+	case d.settingsQueue <- change:
+	case <-d.done:
+	}
+}
+
 // Start provider and watchers.
 func (d *Display) Start() error {
 	w, h := d.GetDimensions()
@@ -139,6 +254,7 @@ func (d *Display) Start() error {
 		return err
 	}
 	go d.watchChannels()
+	d.clipboard.add(d)
 	return nil
 }
 
@@ -147,6 +263,7 @@ func (d *Display) Close() error {
 	var err error
 	d.closeOnce.Do(func() {
 		close(d.done)
+		d.clipboard.remove(d)
 		close(d.fbReqQueue)
 		close(d.ptrEvQueue)
 		close(d.keyEvQueue)
@@ -154,9 +271,13 @@ func (d *Display) Close() error {
 
 		err = d.displayProvider.Close()
 		d.displayProvider = nil
+		if d.cursorSource != nil {
+			_ = d.cursorSource.Close()
+		}
 
 		d.downKeys = nil
 		d.outBuf = nil
+		d.damage.reset()
 	})
 	return err
 }
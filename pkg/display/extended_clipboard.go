@@ -0,0 +1,315 @@
+package display
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
+	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
+)
+
+// encodingExtendedClipboard is 0xC0A1E5CE.
+const encodingExtendedClipboard = -1063131698
+
+const cmdServerCutText = 3
+
+// Extended clipboard formats and actions, as flags of a message.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#extended-clipboard-pseudo-encoding
+const (
+	clipboardText    = 1 << 0
+	clipboardRTF     = 1 << 1
+	clipboardHTML    = 1 << 2
+	clipboardFormats = 0xffff
+
+	clipboardCaps    = 1 << 24
+	clipboardRequest = 1 << 25
+	clipboardPeek    = 1 << 26
+	clipboardNotify  = 1 << 27
+	clipboardProvide = 1 << 28
+	clipboardActions = clipboardCaps | clipboardRequest | clipboardPeek | clipboardNotify | clipboardProvide
+
+	// clipboardDefaultActions are what a client that never sent its caps is assumed to do.
+	clipboardDefaultActions = clipboardRequest | clipboardNotify | clipboardProvide
+)
+
+// ClipboardLimits are the largest clipboard contents accepted from clients in each format,
+// in bytes. Zero uses the limit in DefaultClipboardLimits, negative refuses the format.
+type ClipboardLimits struct {
+	Text, RTF, HTML int
+}
+
+// DefaultClipboardLimits are used for any limits not set.
+var DefaultClipboardLimits = ClipboardLimits{Text: 1 << 20, RTF: 4 << 20, HTML: 4 << 20}
+
+// forFormat returns the limit for a format flag, which is 0 if it is refused.
+func (l ClipboardLimits) forFormat(format uint32) int {
+	var limit, def int
+	switch format {
+	case clipboardText:
+		limit, def = l.Text, DefaultClipboardLimits.Text
+	case clipboardRTF:
+		limit, def = l.RTF, DefaultClipboardLimits.RTF
+	case clipboardHTML:
+		limit, def = l.HTML, DefaultClipboardLimits.HTML
+	default:
+		return 0
+	}
+	switch {
+	case limit == 0:
+		return def
+	case limit < 0:
+		return 0
+	}
+	return limit
+}
+
+// formats returns the flags of the formats that are accepted.
+func (l ClipboardLimits) formats() uint32 {
+	var out uint32
+	for _, f := range []uint32{clipboardText, clipboardRTF, clipboardHTML} {
+		if l.forFormat(f) > 0 {
+			out |= f
+		}
+	}
+	return out
+}
+
+// clipboardData is clipboard contents, with text and HTML in Go strings.
+type clipboardData struct {
+	text, rtf, html string
+}
+
+// formats returns the flags of the formats clip has.
+func (clip *clipboardData) formats() uint32 {
+	var formats uint32
+	for f, v := range map[uint32]string{clipboardText: clip.text, clipboardRTF: clip.rtf, clipboardHTML: clip.html} {
+		if v != "" {
+			formats |= f
+		}
+	}
+	return formats
+}
+
+// get returns clip in a format.
+func (clip *clipboardData) get(format uint32) string {
+	switch format {
+	case clipboardText:
+		return clip.text
+	case clipboardRTF:
+		return clip.rtf
+	case clipboardHTML:
+		return clip.html
+	}
+	return ""
+}
+
+// MaxCutTextSize returns the largest cut text message the client may send, either plain
+// text or extended clipboard data. Extended data is compressed, but allow for data that
+// doesn't compress and the sizes in front of each format.
+func (d *Display) MaxCutTextSize(extended bool) int {
+	if !extended {
+		return d.clipboardLimits.forFormat(clipboardText)
+	}
+	total := 1024
+	for _, f := range []uint32{clipboardText, clipboardRTF, clipboardHTML} {
+		limit := d.clipboardLimits.forFormat(f)
+		total += limit + limit/1000
+	}
+	return total
+}
+
+// queueExtendedClipboard hands whether the client supports the extended clipboard to the
+// cut-text goroutine, which owns the clipboard state.
+func (d *Display) queueExtendedClipboard(encs []int32) {
+	select {
+	case d.extClipboardQueue <- slices.Contains(encs, encodingExtendedClipboard):
+	case <-d.done:
+	}
+}
+
+// setExtendedClipboard records whether the client supports the extended clipboard, and
+// sends our caps when it first says it does.
+func (d *Display) setExtendedClipboard(ext bool) {
+	if ext && !d.extClipboard {
+		d.clientClipFlags = clipboardText | clipboardDefaultActions
+		d.sendClipboardCaps()
+	}
+	d.extClipboard = ext
+}
+
+// handleExtendedClipboard handles an extended clipboard message from the client.
+func (d *Display) handleExtendedClipboard(msg []byte) {
+	if len(msg) < 4 {
+		log.Warning("Ignoring short extended clipboard message")
+		return
+	}
+	flags := binary.BigEndian.Uint32(msg)
+	data := msg[4:]
+	formats := flags & clipboardFormats
+	switch {
+	case flags&clipboardCaps != 0:
+		d.clientClipFlags = flags
+		d.clientClipLimits = make(map[uint32]int)
+		for f := uint32(1); f&clipboardFormats != 0 && len(data) >= 4; f <<= 1 {
+			if formats&f != 0 {
+				d.clientClipLimits[f] = int(binary.BigEndian.Uint32(data))
+				data = data[4:]
+			}
+		}
+		log.Debugf("Client clipboard caps: %#x", flags)
+
+	case flags&clipboardRequest != 0:
+		// Clients that may not read the clipboard are told it is empty.
+		clip := &clipboardData{}
+		if d.canReadClipboard() {
+			clip = d.clipboard.current()
+		}
+		d.provideClipboard(clip, formats)
+
+	case flags&clipboardPeek != 0:
+		clip := &clipboardData{}
+		if d.canReadClipboard() {
+			clip = d.clipboard.current()
+		}
+		d.notifyClipboard(clip)
+
+	case flags&clipboardNotify != 0:
+		// Ask for whatever we can use of the client's new clipboard.
+		if want := formats & d.clipboardLimits.formats(); want != 0 && d.canWriteClipboard() &&
+			d.clientClipFlags&clipboardProvide != 0 {
+			d.sendExtendedClipboard(clipboardRequest|want, nil)
+		}
+
+	case flags&clipboardProvide != 0:
+		if !d.canWriteClipboard() {
+			return
+		}
+		clip, err := d.readProvide(formats, data)
+		if err != nil {
+			log.Warning("Could not read clipboard from client: ", err)
+			return
+		}
+		d.writeClipboard(clip)
+	}
+}
+
+// readProvide reads the contents of a Provide message, in each of the formats in its flags.
+// Reading stops at a format over its limit, since what follows can't be found without
+// inflating it.
+func (d *Display) readProvide(formats uint32, data []byte) (*clipboardData, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	clip := &clipboardData{}
+	for f := uint32(1); f&clipboardFormats != 0; f <<= 1 {
+		if formats&f == 0 {
+			continue
+		}
+		var size uint32
+		if err := binary.Read(zr, binary.BigEndian, &size); err != nil {
+			return nil, err
+		}
+		if int64(size) > int64(d.clipboardLimits.forFormat(f)) {
+			log.Warningf("Dropping clipboard format %#x of %d bytes, over the limit", f, size)
+			break
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(zr, buf); err != nil {
+			return nil, err
+		}
+		switch f {
+		case clipboardText:
+			clip.text = fromClipboardText(buf)
+		case clipboardRTF:
+			clip.rtf = fromClipboardText(buf)
+		case clipboardHTML:
+			clip.html = fromClipboardText(buf)
+		}
+	}
+	return clip, nil
+}
+
+// writeClipboard puts the client's clipboard on the host. The host clipboard only takes
+// plain text, so the rest is kept to give back to clients while the text is still there.
+func (d *Display) writeClipboard(clip *clipboardData) {
+	if clip.text == "" && (clip.rtf != "" || clip.html != "") {
+		log.Debug("Ignoring clipboard without plain text, the host only takes text")
+		return
+	}
+	d.clipboard.setFromClient(d, clip)
+}
+
+// provideClipboard sends the client clip in the formats it asked for.
+func (d *Display) provideClipboard(clip *clipboardData, formats uint32) {
+	var provided uint32
+	data := new(bytes.Buffer)
+	zw := zlib.NewWriter(data)
+	for f := uint32(1); f&clipboardFormats != 0; f <<= 1 {
+		value := clip.get(f)
+		if formats&f == 0 || value == "" {
+			continue
+		}
+		b := toClipboardText(value)
+		provided |= f
+		util.Write(zw, uint32(len(b)))
+		_, _ = zw.Write(b)
+	}
+	if err := zw.Close(); err != nil {
+		log.Error("Could not compress clipboard: ", err)
+		return
+	}
+	d.sendExtendedClipboard(clipboardProvide|provided, data.Bytes())
+}
+
+// notifyClipboard tells the client which formats clip has.
+func (d *Display) notifyClipboard(clip *clipboardData) {
+	d.sendExtendedClipboard(clipboardNotify|clip.formats(), nil)
+}
+
+// sendClipboardCaps tells the client what the server does with the clipboard, and how much
+// it takes in each format.
+func (d *Display) sendClipboardCaps() {
+	formats := d.clipboardLimits.formats()
+	data := new(bytes.Buffer)
+	for f := uint32(1); f&clipboardFormats != 0; f <<= 1 {
+		if formats&f != 0 {
+			util.Write(data, uint32(d.clipboardLimits.forFormat(f)))
+		}
+	}
+	d.sendExtendedClipboard(clipboardActions|formats, data.Bytes())
+}
+
+// sendExtendedClipboard sends an extended clipboard message, flagged by a negative length.
+func (d *Display) sendExtendedClipboard(flags uint32, data []byte) {
+	if d.buf == nil || d.buf.IsClosed() {
+		return
+	}
+	buf := new(bytes.Buffer)
+	util.Write(buf, uint8(cmdServerCutText))
+	util.Write(buf, [3]uint8{}) // padding
+	util.Write(buf, -int32(4+len(data)))
+	util.Write(buf, flags)
+	buf.Write(data)
+	d.buf.DispatchWait(buf.Bytes())
+}
+
+// fromClipboardText decodes extended clipboard text, which ends in a NUL and has CRLF line
+// endings.
+func fromClipboardText(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.ReplaceAll(string(b), "\r\n", "\n")
+}
+
+// toClipboardText encodes text for the extended clipboard.
+func toClipboardText(s string) []byte {
+	s = strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+	return append([]byte(s), 0)
+}
@@ -3,7 +3,6 @@ package display
 import (
 	"bytes"
 	"image"
-	"image/draw"
 	"sync"
 
 	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
@@ -24,18 +23,39 @@ func (d *Display) pushFrame(ur *types.FrameBufferUpdateRequest) {
 	if li == nil {
 		return
 	}
+	clip := image.Rect(int(ur.X), int(ur.Y), int(ur.X)+int(ur.Width), int(ur.Y)+int(ur.Height))
+	log.Debug("Pushing frame to client")
 	if ur.Incremental() {
-		li = truncateImage(ur, li)
-	}
-	if li == nil || li.Bounds().Empty() {
+		d.pushChanges(li, clip)
 		return
 	}
-	log.Debug("Pushing frame to client")
-	d.pushImage(li)
+	if k, ok := d.GetCurrentEncoding().(encodings.Keyframer); ok {
+		// The client may have lost its picture, so don't send anything relying on it.
+		k.ForceKeyframe()
+	}
+	if r := clip.Intersect(li.Bounds()); !r.Empty() {
+		d.pushImage(li, nil, []image.Rectangle{r})
+	}
 }
 
-func (d *Display) pushImage(img *image.RGBA) {
-	if img == nil || img.Bounds().Empty() {
+// pushChanges sends the areas of img within clip that changed since the last update, having
+// the client move what it already has where it can.
+func (d *Display) pushChanges(img *image.RGBA, clip image.Rectangle) {
+	var copies []copyRect
+	if _, whole := d.GetCurrentEncoding().(encodings.WholeFrame); d.copyRect && !whole {
+		copies = d.damage.findCopies(img, clip)
+	}
+	d.pushImage(img, copies, d.damage.damage(img, clip))
+}
+
+// pushImage sends the given copies and areas of img to the client in a single update,
+// along with the cursor if its shape changed.
+func (d *Display) pushImage(img *image.RGBA, copies []copyRect, rects []image.Rectangle) {
+	if img == nil {
+		return
+	}
+	cursor := d.cursorUpdate()
+	if len(copies)+len(rects) == 0 && cursor == nil {
 		return
 	}
 	// If the writer is closed, drop immediately.
@@ -43,61 +63,79 @@ func (d *Display) pushImage(img *image.RGBA) {
 		return
 	}
 
-	b := img.Bounds()
 	format := d.GetPixelFormat()
-	if format.TrueColour == 0 {
-		// Fallback to a known-good format to keep the session alive
-		format = DefaultPixelFormat
-		d.SetPixelFormat(format)
-	}
 	enc := d.GetCurrentEncoding()
 	if enc == nil {
 		enc = &encodings.RawEncoding{}
 	}
+	if _, ok := enc.(encodings.WholeFrame); ok && len(rects) > 0 {
+		rects = []image.Rectangle{img.Bounds()}
+	}
 
 	// Use pooled buffer to reduce allocations
 	buf := fbBufPool.Get().(*bytes.Buffer)
 	buf.Reset()
 	defer fbBufPool.Put(buf)
 
+	// Split the update into rectangles the encoder can handle.
+	var split []image.Rectangle
+	for _, r := range rects {
+		split = append(split, encodings.SplitRect(enc, r)...)
+	}
+
 	// header
 	util.Write(buf, uint8(cmdFramebufferUpdate))
-	util.Write(buf, uint8(0))  // padding
-	util.Write(buf, uint16(1)) // rectangles=1
+	util.Write(buf, uint8(0)) // padding
+	count := len(copies) + len(split)
+	if cursor != nil {
+		count++
+	}
+	util.Write(buf, uint16(count))
+
+	if cursor != nil {
+		d.writeCursor(buf, cursor)
+	}
+
+	// Copies go first, while the areas they come from still hold what the client expects.
+	for _, c := range copies {
+		util.PackStruct(buf, &types.FrameBufferRectangle{
+			X:       uint16(c.r.Min.X),
+			Y:       uint16(c.r.Min.Y),
+			Width:   uint16(c.r.Dx()),
+			Height:  uint16(c.r.Dy()),
+			EncType: encodingCopyRect,
+		})
+		util.Write(buf, uint16(c.src.X))
+		util.Write(buf, uint16(c.src.Y))
+	}
 
-	// rectangle header
-	util.PackStruct(buf, &types.FrameBufferRectangle{
-		X:       uint16(b.Min.X),
-		Y:       uint16(b.Min.Y),
-		Width:   uint16(b.Dx()),
-		Height:  uint16(b.Dy()),
-		EncType: enc.Code(),
-	})
+	for _, r := range split {
+		// rectangle header
+		util.PackStruct(buf, &types.FrameBufferRectangle{
+			X:       uint16(r.Min.X),
+			Y:       uint16(r.Min.Y),
+			Width:   uint16(r.Dx()),
+			Height:  uint16(r.Dy()),
+			EncType: enc.Code(),
+		})
 
-	// payload by encoder
-	enc.HandleBuffer(buf, d.GetPixelFormat(), img)
+		// payload by encoder
+		enc.HandleBuffer(buf, format, img.SubImage(r).(*image.RGBA))
+	}
 
 	// Final guard: drop if closed
 	if d.buf != nil && d.buf.IsClosed() {
 		return
 	}
-	// Keep only latest framebuffer in the queue (avoid backlog/latency).
-	d.buf.DispatchLatest(buf.Bytes())
-}
-
-func truncateImage(ur *types.FrameBufferUpdateRequest, img *image.RGBA) *image.RGBA {
-	r := image.Rect(
-		int(ur.X),
-		int(ur.Y),
-		int(ur.X)+int(ur.Width),
-		int(ur.Y)+int(ur.Height),
-	).Intersect(img.Bounds())
-
-	if r.Empty() {
-		return image.NewRGBA(image.Rect(0, 0, 0, 0))
+	// Updates can't be dropped, stateful encodings rely on the client seeing every one.
+	// The pooled buffer is reused once we return, so the writer gets a copy.
+	d.buf.DispatchWait(bytes.Clone(buf.Bytes()))
+	d.damage.markSent(img, rects)
+	d.congestion.sentUpdate(buf.Len())
+	if d.fence && d.continuous {
+		d.sendPing()
+	}
+	if cursor != nil {
+		d.cursorSerial, d.cursorSent = cursor.Serial, true
 	}
-
-	out := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
-	draw.Draw(out, out.Bounds(), img, r.Min, draw.Src)
-	return out
 }
@@ -0,0 +1,105 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"runtime"
+	"sync"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xfixes"
+)
+
+// Cursor is the shape of the host's mouse pointer.
+type Cursor struct {
+	Image   *image.NRGBA
+	Hotspot image.Point
+	// Serial changes whenever the shape does.
+	Serial uint32
+}
+
+// A CursorSource reports the shape of the host's mouse pointer, for clients that draw the
+// pointer themselves.
+type CursorSource interface {
+	Cursor() (*Cursor, error)
+	Close() error
+}
+
+// PointerHider is implemented by display providers that can leave the pointer out of the
+// frames they capture.
+type PointerHider interface {
+	HidePointer(hide bool)
+}
+
+// CursorShapesSupported reports whether the pointer's shape can be sent to clients, for
+// them to draw. The shape is read from X11, so Windows doesn't have it. Neither does macOS,
+// whose screen capture only leaves the pointer out from the start of a capture.
+func CursorShapesSupported() bool { return runtime.GOOS != "windows" && runtime.GOOS != "darwin" }
+
+// NewCursorSource returns a source for the pointer shape on the X server in $DISPLAY, read
+// with the XFixes extension.
+func NewCursorSource() (CursorSource, error) {
+	if !CursorShapesSupported() {
+		return nil, errors.New("cursor shapes are not supported on " + runtime.GOOS)
+	}
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return nil, err
+	}
+	if err := xfixes.Init(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The server ignores XFixes requests until the client has said which version it speaks.
+	if _, err := xfixes.QueryVersion(conn, 4, 0).Reply(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not query XFixes version: %w", err)
+	}
+	return &xfixesCursor{conn: conn}, nil
+}
+
+// xfixesCursor reads the pointer shape with XFixes, only converting it when it changes.
+type xfixesCursor struct {
+	mu   sync.Mutex
+	conn *xgb.Conn
+	last *Cursor
+}
+
+// Cursor returns the current pointer shape.
+func (x *xfixesCursor) Cursor() (*Cursor, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	reply, err := xfixes.GetCursorImage(x.conn).Reply()
+	if err != nil {
+		return nil, err
+	}
+	if x.last != nil && x.last.Serial == reply.CursorSerial {
+		return x.last, nil
+	}
+	// Pixels come as premultiplied ARGB.
+	img := image.NewNRGBA(image.Rect(0, 0, int(reply.Width), int(reply.Height)))
+	for i, p := range reply.CursorImage[:len(img.Pix)/4] {
+		a := uint8(p >> 24)
+		c := img.Pix[i*4 : i*4+4]
+		c[3] = a
+		if a == 0 {
+			continue
+		}
+		for j, shift := range []uint{16, 8, 0} {
+			c[j] = uint8(min(uint32(uint8(p>>shift))*0xff/uint32(a), 0xff))
+		}
+	}
+	x.last = &Cursor{
+		Image:   img,
+		Hotspot: image.Pt(int(reply.Xhot), int(reply.Yhot)),
+		Serial:  reply.CursorSerial,
+	}
+	return x.last, nil
+}
+
+// Close closes the connection to the X server.
+func (x *xfixesCursor) Close() error {
+	x.conn.Close()
+	return nil
+}
@@ -16,6 +16,7 @@ import (
 // Gstreamer implements a display provider using gstreamer to capture video.
 type Gstreamer struct {
 	pipeline   *gst.Pipeline
+	src        *gst.Element
 	frameQueue chan *image.RGBA // latest-only queue
 
 	// reuse two RGBA buffers to avoid per-frame allocs
@@ -28,39 +29,39 @@ type Gstreamer struct {
 	linkMu     sync.Mutex
 	linkedOnce bool
 
+	// hidePointer leaves the pointer out of frames, for clients that draw it themselves.
+	hidePointer bool
+
 	done chan struct{} // signals Close to appsink/PullFrame
 }
 
-// Close stops the gstreamer pipeline and releases resources.
+// Close stops the gstreamer pipeline and releases resources. The frame queue is left open,
+// as the appsink may still be delivering a frame until the pipeline has stopped, and
+// PullFrame returns once done is closed anyway.
 func (g *Gstreamer) Close() error {
 	if g.done != nil {
-		close(g.done)
-	}
-
-	if g.frameQueue != nil {
-	drain:
-		for {
-			select {
-			case <-g.frameQueue:
-			default:
-				break drain
-			}
+		select {
+		case <-g.done:
+		default:
+			close(g.done)
 		}
-		close(g.frameQueue)
-		g.frameQueue = nil
 	}
+	g.frameQueue = nil
 
+	var err error
 	if g.pipeline != nil {
-		err := g.pipeline.SetState(gst.StateNull)
+		// Setting the state to NULL waits for the streaming threads, so no callbacks run
+		// after this.
+		err = g.pipeline.SetState(gst.StateNull)
 		g.pipeline.Unref()
 		g.pipeline = nil
-		return err
+		g.src = nil
 	}
 
 	g.workA = nil
 	g.workB = nil
 
-	return nil
+	return err
 }
 
 // PullFrame returns a frame or nil if closed.
@@ -73,6 +74,16 @@ func (g *Gstreamer) PullFrame() *image.RGBA {
 	}
 }
 
+// HidePointer sets whether the pointer is left out of frames. Screen capture elements look
+// at this every frame, except on macOS where it would only apply from the next Start, so
+// cursor shapes aren't offered there.
+func (g *Gstreamer) HidePointer(hide bool) {
+	g.hidePointer = hide
+	if g.src != nil {
+		setShowPointer(g.src, !hide)
+	}
+}
+
 // Start will start the gstreamer pipeline and send images to the frame queue.
 func (g *Gstreamer) Start(width, height int) error {
 	log.Debug("Building gstreamer pipeline for display connection")
@@ -82,6 +93,9 @@ func (g *Gstreamer) Start(width, height int) error {
 	g.workB = image.NewRGBA(image.Rect(0, 0, width, height))
 	g.linkedOnce = false
 	g.done = make(chan struct{})
+	// The callbacks keep to the channels of this run, as a restart replaces them.
+	frames, done := g.frameQueue, g.done
+	workA, workB := g.workA, g.workB
 
 	pipeline, err := gst.NewPipeline("")
 	if err != nil {
@@ -89,10 +103,11 @@ func (g *Gstreamer) Start(width, height int) error {
 	}
 
 	// Get the screen capture element depending on the OS
-	src, err := getScreenCaptureElement()
+	src, err := getScreenCaptureElement(!g.hidePointer)
 	if err != nil {
 		return err
 	}
+	g.src = src
 
 	// Let decodebin decide best path
 	decodebin, err := gst.NewElement("decodebin")
@@ -158,7 +173,7 @@ func (g *Gstreamer) Start(width, height int) error {
 				sink.SetCallbacks(&app.SinkCallbacks{
 					NewSampleFunc: func(self *app.Sink) gst.FlowReturn {
 						select {
-						case <-g.done:
+						case <-done:
 							return gst.FlowEOS
 						default:
 						}
@@ -175,9 +190,9 @@ func (g *Gstreamer) Start(width, height int) error {
 						}
 
 						// Choose reusable destination
-						dst := g.workA
+						dst := workA
 						if g.swap {
-							dst = g.workB
+							dst = workB
 						}
 						g.swap = !g.swap
 
@@ -191,18 +206,18 @@ func (g *Gstreamer) Start(width, height int) error {
 
 						// Non-blocking enqueue (keep latest)
 						select {
-						case <-g.done:
+						case <-done:
 							return gst.FlowEOS
-						case g.frameQueue <- dst:
+						case frames <- dst:
 						default:
 							select {
-							case <-g.frameQueue:
+							case <-frames:
 							default:
 							}
 							select {
-							case <-g.done:
+							case <-done:
 								return gst.FlowEOS
-							case g.frameQueue <- dst:
+							case frames <- dst:
 							default:
 							}
 						}
@@ -238,33 +253,46 @@ func (g *Gstreamer) Start(width, height int) error {
 	return nil
 }
 
-func getScreenCaptureElement() (elem *gst.Element, err error) {
+func getScreenCaptureElement(showPointer bool) (elem *gst.Element, err error) {
 	switch runtime.GOOS {
 	case "windows":
 		log.Debug("Detected Windows, using gdiscreencapsrc")
 		elem, err = gst.NewElement("gdiscreencapsrc")
-		if err == nil {
-			_ = elem.SetProperty("cursor", true)
-		}
 	case "darwin":
 		log.Debug("Detected macOS, using avfvideosrc")
 		elem, err = gst.NewElement("avfvideosrc")
 		if err == nil {
-			if err = elem.SetProperty("capture-screen", true); err == nil {
-				_ = elem.SetProperty("capture-screen-cursor", true)
-			}
+			err = elem.SetProperty("capture-screen", true)
 		}
 	default:
 		log.Debug("Detected Linux, using ximagesrc")
 		elem, err = gst.NewElement("ximagesrc")
 		if err == nil {
-			_ = elem.SetProperty("show-pointer", true)
 			_ = elem.SetProperty("use-damage", false)
 		}
 	}
+	if err == nil {
+		setShowPointer(elem, showPointer)
+	}
 	return
 }
 
+// setShowPointer sets whether a screen capture element draws the pointer into frames.
+func setShowPointer(elem *gst.Element, show bool) {
+	var prop string
+	switch runtime.GOOS {
+	case "windows":
+		prop = "cursor"
+	case "darwin":
+		prop = "capture-screen-cursor"
+	default:
+		prop = "show-pointer"
+	}
+	if err := elem.SetProperty(prop, show); err != nil {
+		log.Debug("Could not set ", prop, ": ", err)
+	}
+}
+
 func runAllUntilError(fs []func() error) error {
 	for _, f := range fs {
 		if err := f(); err != nil {
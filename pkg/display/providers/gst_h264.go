@@ -0,0 +1,118 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/encodings"
+)
+
+// h264EncoderElements are the software H.264 encoders to use, in order of preference, each
+// tuned to emit a frame for every frame it is given.
+var h264EncoderElements = []struct{ name, props string }{
+	{"x264enc", "tune=zerolatency speed-preset=ultrafast bframes=0 key-int-max=300"},
+	{"openh264enc", "complexity=low"},
+}
+
+// h264EncodeTimeout bounds how long to wait for the encoder to produce a frame.
+const h264EncodeTimeout = time.Second
+
+// H264Available reports whether GStreamer has an H.264 encoder to use.
+func H264Available() bool { return h264EncoderElement() != "" }
+
+func h264EncoderElement() string {
+	for _, e := range h264EncoderElements {
+		if gst.Find(e.name) != nil {
+			return e.name + " " + e.props
+		}
+	}
+	return ""
+}
+
+// gstH264Encoder encodes frames by pushing them through appsrc ! videoconvert ! encoder !
+// appsink.
+type gstH264Encoder struct {
+	pipeline *gst.Pipeline
+	src      *app.Source
+	sink     *app.Sink
+	pts      time.Duration
+	start    time.Time
+}
+
+// NewH264Encoder starts a GStreamer pipeline that encodes RGBA frames of the given size to
+// H.264.
+func NewH264Encoder(width, height int) (encodings.H264Encoder, error) {
+	encoder := h264EncoderElement()
+	if encoder == "" {
+		return nil, errors.New("no H.264 encoder element is available")
+	}
+	pipeline, err := gst.NewPipelineFromString(fmt.Sprintf(
+		"appsrc name=src format=time is-live=true ! videoconvert ! video/x-raw,format=I420 ! %s ! "+
+			"h264parse config-interval=-1 ! video/x-h264,stream-format=byte-stream,alignment=au ! "+
+			"appsink name=sink sync=false", encoder))
+	if err != nil {
+		return nil, err
+	}
+	g := &gstH264Encoder{pipeline: pipeline, start: time.Now()}
+	if err := runAllUntilError([]func() error{
+		func() error {
+			elem, err := pipeline.GetElementByName("src")
+			if err != nil {
+				return err
+			}
+			g.src = app.SrcFromElement(elem)
+			g.src.SetCaps(gst.NewCapsFromString(fmt.Sprintf("video/x-raw,format=RGBA,width=%d,height=%d,framerate=0/1", width, height)))
+			return nil
+		},
+		func() error {
+			elem, err := pipeline.GetElementByName("sink")
+			if err != nil {
+				return err
+			}
+			g.sink = app.SinkFromElement(elem)
+			return nil
+		},
+		func() error { return pipeline.SetState(gst.StatePlaying) },
+	}); err != nil {
+		_ = g.Close()
+		return nil, err
+	}
+	return g, nil
+}
+
+// Encode pushes a frame through the pipeline and waits for the encoded result.
+func (g *gstH264Encoder) Encode(img *image.RGBA, keyframe bool) ([]byte, error) {
+	if keyframe {
+		ev := gst.NewCustomEvent(gst.EventTypeCustomDownstream,
+			gst.NewStructureFromString("GstForceKeyUnit, all-headers=(boolean)true"))
+		g.src.SendEvent(ev)
+	}
+	buf := gst.NewBufferFromBytes(img.Pix)
+	// Encoders want timestamps that always increase.
+	g.pts = max(g.pts+time.Millisecond, time.Since(g.start))
+	buf.SetPresentationTimestamp(gst.ClockTime(g.pts))
+	if ret := g.src.PushBuffer(buf); ret != gst.FlowOK {
+		return nil, fmt.Errorf("could not push frame: %s", ret)
+	}
+	sample := g.sink.TryPullSample(gst.ClockTime(h264EncodeTimeout))
+	if sample == nil {
+		return nil, errors.New("timed out waiting for encoded frame")
+	}
+	defer sample.Unref()
+	return sample.GetBuffer().Bytes(), nil
+}
+
+// Close stops the pipeline.
+func (g *gstH264Encoder) Close() error {
+	if g.pipeline == nil {
+		return nil
+	}
+	err := g.pipeline.SetState(gst.StateNull)
+	g.pipeline.Unref()
+	g.pipeline = nil
+	return err
+}
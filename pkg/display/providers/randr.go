@@ -0,0 +1,126 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/randr"
+	"github.com/jezek/xgb/xproto"
+)
+
+// ErrResizeUnsupported is returned by ResizeScreen when the host display can't be resized.
+var ErrResizeUnsupported = errors.New("the display can't be resized")
+
+// ResizeScreen changes the size of the X screen in $DISPLAY with RandR, adding a mode of
+// that size to its first active output if there isn't one. This suits virtual displays such
+// as Xvfb, where any size will do.
+func ResizeScreen(width, height int) error {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrResizeUnsupported, err)
+	}
+	defer conn.Close()
+	if err := randr.Init(conn); err != nil {
+		return fmt.Errorf("%w: %v", ErrResizeUnsupported, err)
+	}
+	// Outputs and CRTCs arrived in RandR 1.2.
+	if v, err := randr.QueryVersion(conn, 1, 2).Reply(); err != nil {
+		return fmt.Errorf("%w: %v", ErrResizeUnsupported, err)
+	} else if v.MajorVersion < 1 || (v.MajorVersion == 1 && v.MinorVersion < 2) {
+		return fmt.Errorf("%w: RandR %d.%d is too old", ErrResizeUnsupported, v.MajorVersion, v.MinorVersion)
+	}
+
+	screen := xproto.Setup(conn).DefaultScreen(conn)
+	res, err := randr.GetScreenResources(conn, screen.Root).Reply()
+	if err != nil {
+		return err
+	}
+	output, outputInfo, err := activeOutput(conn, res)
+	if err != nil {
+		return err
+	}
+	crtc, err := randr.GetCrtcInfo(conn, outputInfo.Crtc, res.ConfigTimestamp).Reply()
+	if err != nil {
+		return err
+	}
+	mode, err := screenMode(conn, screen.Root, res, output, outputInfo, width, height)
+	if err != nil {
+		return err
+	}
+
+	// The screen has to hold the CRTC at all times, so the CRTC is off while it changes size.
+	if _, err := randr.SetCrtcConfig(conn, outputInfo.Crtc, xproto.TimeCurrentTime, res.ConfigTimestamp,
+		0, 0, 0, crtc.Rotation, nil).Reply(); err != nil {
+		return err
+	}
+	// Keep the screen's DPI.
+	mmWidth := uint32(int(screen.WidthInMillimeters) * width / int(screen.WidthInPixels))
+	mmHeight := uint32(int(screen.HeightInMillimeters) * height / int(screen.HeightInPixels))
+	resizeErr := randr.SetScreenSizeChecked(conn, screen.Root, uint16(width), uint16(height), mmWidth, mmHeight).Check()
+	if resizeErr != nil {
+		// Put the CRTC back as it was.
+		mode = crtc.Mode
+	}
+	reply, err := randr.SetCrtcConfig(conn, outputInfo.Crtc, xproto.TimeCurrentTime, res.ConfigTimestamp,
+		crtc.X, crtc.Y, mode, crtc.Rotation, crtc.Outputs).Reply()
+	switch {
+	case resizeErr != nil:
+		return resizeErr
+	case err != nil:
+		return err
+	case reply.Status != randr.SetConfigSuccess:
+		return fmt.Errorf("could not set mode, status %d", reply.Status)
+	}
+	return nil
+}
+
+// activeOutput returns the first output that is showing something.
+func activeOutput(conn *xgb.Conn, res *randr.GetScreenResourcesReply) (randr.Output, *randr.GetOutputInfoReply, error) {
+	for _, output := range res.Outputs {
+		info, err := randr.GetOutputInfo(conn, output, res.ConfigTimestamp).Reply()
+		if err != nil {
+			return 0, nil, err
+		}
+		if info.Crtc != 0 {
+			return output, info, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("%w: no active output", ErrResizeUnsupported)
+}
+
+// screenMode returns a mode of the given size for output, creating one if it has none.
+func screenMode(conn *xgb.Conn, root xproto.Window, res *randr.GetScreenResourcesReply,
+	output randr.Output, info *randr.GetOutputInfoReply, width, height int) (randr.Mode, error) {
+	for _, m := range res.Modes {
+		if int(m.Width) != width || int(m.Height) != height {
+			continue
+		}
+		for _, id := range info.Modes {
+			if uint32(id) == m.Id {
+				return id, nil
+			}
+		}
+		// Another output has one, such as from an earlier resize.
+		id := randr.Mode(m.Id)
+		return id, randr.AddOutputModeChecked(conn, output, id).Check()
+	}
+	// Timings don't matter to a virtual display, beyond giving a 60Hz refresh rate.
+	name := fmt.Sprintf("%dx%d", width, height)
+	reply, err := randr.CreateMode(conn, root, randr.ModeInfo{
+		Width:    uint16(width),
+		Height:   uint16(height),
+		DotClock: uint32(width * height * 60),
+		Htotal:   uint16(width),
+		Vtotal:   uint16(height),
+		NameLen:  uint16(len(name)),
+	}, name).Reply()
+	if err != nil {
+		return 0, err
+	}
+	if err := randr.AddOutputModeChecked(conn, output, reply.Mode).Check(); err != nil {
+		_ = randr.DestroyModeChecked(conn, reply.Mode).Check()
+		return 0, err
+	}
+	return reply.Mode, nil
+}
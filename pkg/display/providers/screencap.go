@@ -25,22 +25,16 @@ type ScreenCapture struct {
 
 func (s *ScreenCapture) Close() error {
 	if s.stopCh != nil {
-		close(s.stopCh)
-	}
-	if s.frameQueue != nil {
-	drain:
-		for {
-			select {
-			case <-s.frameQueue:
-			default:
-				break drain
-			}
+		select {
+		case <-s.stopCh:
+		default:
+			close(s.stopCh)
 		}
-		close(s.frameQueue)
-		s.frameQueue = nil
 	}
-
+	// Wait for the capture loop before letting go of the queue, it may be mid-send. The
+	// queue is never closed, PullFrame returns once stopCh is closed.
 	s.wg.Wait()
+	s.frameQueue = nil
 
 	// Release buffers
 	s.workA = nil
@@ -64,6 +58,7 @@ func (s *ScreenCapture) Start(width, height int) error {
 	s.workA = image.NewRGBA(image.Rect(0, 0, width, height))
 	s.workB = image.NewRGBA(image.Rect(0, 0, width, height))
 
+	frames, stop := s.frameQueue, s.stopCh
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
@@ -73,7 +68,7 @@ func (s *ScreenCapture) Start(width, height int) error {
 
 		for {
 			select {
-			case <-s.stopCh:
+			case <-stop:
 				log.Debug("Stopping screen capture")
 				return
 			case <-ticker.C:
@@ -108,14 +103,14 @@ func (s *ScreenCapture) Start(width, height int) error {
 
 				// Non-blocking enqueue keeping only latest
 				select {
-				case s.frameQueue <- dst:
+				case frames <- dst:
 				default:
 					select {
-					case <-s.frameQueue:
+					case <-frames:
 					default:
 					}
 					select {
-					case s.frameQueue <- dst:
+					case frames <- dst:
 					default:
 					}
 				}
@@ -19,7 +19,14 @@ func PackStruct(buf io.Writer, data interface{}) error {
 	for i := 0; i < val.NumField(); i++ {
 		field := val.Field(i)
 		v := field.Interface()
-		// Strings should be pre-sized elsewhere (not common in RFB structs)
+		// Strings are written as their bytes, so fixed size fields such as Tight
+		// capability signatures must be given at their full length.
+		if s, ok := v.(string); ok {
+			if _, err := io.WriteString(buf, s); err != nil {
+				return err
+			}
+			continue
+		}
 		if err := binary.Write(buf, binary.BigEndian, v); err != nil {
 			return err
 		}
@@ -0,0 +1,167 @@
+package rfb
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// AccessRules decide which client addresses may reach the RFB handshake.
+type AccessRules struct {
+	// Allow, if not empty, lists the only networks clients may connect from.
+	Allow []netip.Prefix
+	// Deny lists networks clients may never connect from. It takes precedence over Allow.
+	Deny []netip.Prefix
+	// TrustedProxies lists the proxies whose X-Forwarded-For header is believed for
+	// websocket connections.
+	TrustedProxies []netip.Prefix
+}
+
+// ParsePrefixes parses a list of CIDRs. Bare addresses are treated as single hosts.
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			addr = addr.Unmap()
+			out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, netip.PrefixFrom(p.Addr().Unmap(), p.Bits()).Masked())
+	}
+	return out, nil
+}
+
+// LoadAccessRules reads rules from a file with one rule per line, for example:
+//
+//	# comments and blank lines are ignored
+//	allow 10.0.0.0/8
+//	deny 10.0.13.0/24
+//	trust-proxy 127.0.0.1
+func LoadAccessRules(path string) (*AccessRules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules := &AccessRules{}
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a rule and a network", path, lineno)
+		}
+		prefixes, err := ParsePrefixes(fields[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineno, err)
+		}
+		switch fields[0] {
+		case "allow":
+			rules.Allow = append(rules.Allow, prefixes...)
+		case "deny":
+			rules.Deny = append(rules.Deny, prefixes...)
+		case "trust-proxy":
+			rules.TrustedProxies = append(rules.TrustedProxies, prefixes...)
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown rule %q", path, lineno, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Merge returns the union of both sets of rules.
+func (r *AccessRules) Merge(other *AccessRules) *AccessRules {
+	if other == nil {
+		return r
+	}
+	return &AccessRules{
+		Allow:          append(append([]netip.Prefix{}, r.Allow...), other.Allow...),
+		Deny:           append(append([]netip.Prefix{}, r.Deny...), other.Deny...),
+		TrustedProxies: append(append([]netip.Prefix{}, r.TrustedProxies...), other.TrustedProxies...),
+	}
+}
+
+// Check returns an error describing why the address is not allowed to connect, or nil.
+func (r *AccessRules) Check(addr string) error {
+	if r == nil || (len(r.Allow) == 0 && len(r.Deny) == 0) {
+		return nil
+	}
+	ip, err := netip.ParseAddr(hostOf(addr))
+	if err != nil {
+		return fmt.Errorf("could not parse address %q", addr)
+	}
+	ip = ip.Unmap()
+	if p, ok := matchPrefix(r.Deny, ip); ok {
+		return fmt.Errorf("denied by rule %s", p)
+	}
+	if len(r.Allow) > 0 {
+		if _, ok := matchPrefix(r.Allow, ip); !ok {
+			return fmt.Errorf("not in any allowed network")
+		}
+	}
+	return nil
+}
+
+// clientAddr returns the address of the client behind a websocket request. X-Forwarded-For
+// is only followed while the hops it names are trusted proxies.
+func (r *AccessRules) clientAddr(req *http.Request) string {
+	addr := req.RemoteAddr
+	if r == nil || len(r.TrustedProxies) == 0 || !r.isTrustedProxy(addr) {
+		return addr
+	}
+	var hops []string
+	for _, h := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	// The rightmost entries were added by the proxies closest to us.
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		addr = hop
+		if !r.isTrustedProxy(hop) {
+			break
+		}
+	}
+	return addr
+}
+
+func (r *AccessRules) isTrustedProxy(addr string) bool {
+	ip, err := netip.ParseAddr(hostOf(addr))
+	if err != nil {
+		return false
+	}
+	_, ok := matchPrefix(r.TrustedProxies, ip.Unmap())
+	return ok
+}
+
+func matchPrefix(prefixes []netip.Prefix, ip netip.Addr) (netip.Prefix, bool) {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return p, true
+		}
+	}
+	return netip.Prefix{}, false
+}
@@ -0,0 +1,149 @@
+package rfb
+
+import (
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	got, err := ParsePrefixes([]string{"10.0.0.0/8", " 192.168.1.7 ", "", "10.1.2.3/16", "::ffff:172.16.0.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.7/32"),
+		netip.MustParsePrefix("10.1.0.0/16"),
+		netip.MustParsePrefix("172.16.0.1/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ParsePrefixes() = %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"10.0.0", "10.0.0.0/33", "example.com", "10.0.0.0/"} {
+		if _, err := ParsePrefixes([]string{bad}); err == nil {
+			t.Errorf("ParsePrefixes(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestLoadAccessRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules")
+	content := "# office\n\nallow 10.0.0.0/8\n  deny   10.0.13.0/24  \ntrust-proxy 127.0.0.1\nallow ::1\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadAccessRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAllow := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	if !slices.Equal(rules.Allow, wantAllow) {
+		t.Errorf("Allow = %v, want %v", rules.Allow, wantAllow)
+	}
+	if want := []netip.Prefix{netip.MustParsePrefix("10.0.13.0/24")}; !slices.Equal(rules.Deny, want) {
+		t.Errorf("Deny = %v, want %v", rules.Deny, want)
+	}
+	if want := []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}; !slices.Equal(rules.TrustedProxies, want) {
+		t.Errorf("TrustedProxies = %v, want %v", rules.TrustedProxies, want)
+	}
+
+	for _, bad := range []string{"permit 10.0.0.0/8\n", "allow\n", "allow 10.0.0.0/8 10.1.0.0/16\n", "deny 300.0.0.1\n"} {
+		path := filepath.Join(t.TempDir(), "rules")
+		if err := os.WriteFile(path, []byte(bad), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadAccessRules(path); err == nil {
+			t.Errorf("LoadAccessRules() of %q succeeded", bad)
+		}
+	}
+}
+
+func TestAccessRulesCheck(t *testing.T) {
+	rules := &AccessRules{
+		Allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")},
+		Deny:  []netip.Prefix{netip.MustParsePrefix("10.0.13.0/24")},
+	}
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"10.1.2.3:5900", true},
+		{"10.1.2.3", true},
+		{"[::ffff:10.1.2.3]:5900", true},
+		{"[2001:db8::1]:5900", true},
+		{"10.0.13.7:5900", false},
+		{"192.168.1.1:5900", false},
+		{"[2001:db9::1]:5900", false},
+		{"not an address", false},
+	}
+	for _, tt := range tests {
+		if err := rules.Check(tt.addr); (err == nil) != tt.allowed {
+			t.Errorf("Check(%q) = %v, want allowed %v", tt.addr, err, tt.allowed)
+		}
+	}
+
+	denyOnly := &AccessRules{Deny: []netip.Prefix{netip.MustParsePrefix("10.0.13.0/24")}}
+	if err := denyOnly.Check("192.168.1.1:5900"); err != nil {
+		t.Errorf("Check() with only deny rules = %v, want allowed", err)
+	}
+	var none *AccessRules
+	if err := none.Check("192.168.1.1:5900"); err != nil {
+		t.Errorf("Check() without rules = %v, want allowed", err)
+	}
+}
+
+func TestAccessRulesMerge(t *testing.T) {
+	a := &AccessRules{Allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	b := &AccessRules{
+		Allow: []netip.Prefix{netip.MustParsePrefix("192.168.0.0/16")},
+		Deny:  []netip.Prefix{netip.MustParsePrefix("10.0.13.0/24")},
+	}
+	merged := a.Merge(b)
+	if len(merged.Allow) != 2 || len(merged.Deny) != 1 {
+		t.Errorf("Merge() = %+v", merged)
+	}
+	if len(a.Allow) != 1 || len(a.Deny) != 0 {
+		t.Errorf("Merge() changed its receiver to %+v", a)
+	}
+	if a.Merge(nil) != a {
+		t.Error("Merge(nil) did not return the receiver")
+	}
+}
+
+func TestAccessRulesClientAddr(t *testing.T) {
+	rules := &AccessRules{TrustedProxies: []netip.Prefix{
+		netip.MustParsePrefix("127.0.0.1/32"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+	}}
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{"no proxy", "192.168.1.5:4000", nil, "192.168.1.5:4000"},
+		{"untrusted proxy", "192.168.1.5:4000", []string{"1.2.3.4"}, "192.168.1.5:4000"},
+		{"trusted proxy", "127.0.0.1:4000", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"trusted proxy without header", "127.0.0.1:4000", nil, "127.0.0.1:4000"},
+		{"chain of trusted proxies", "127.0.0.1:4000", []string{"1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+		{"spoofed hop before an untrusted one", "127.0.0.1:4000", []string{"10.9.9.9, 5.6.7.8"}, "5.6.7.8"},
+		{"several headers", "127.0.0.1:4000", []string{"1.2.3.4", "10.0.0.2"}, "1.2.3.4"},
+		{"empty hops", "127.0.0.1:4000", []string{" , 1.2.3.4 , "}, "1.2.3.4"},
+		{"only trusted hops", "127.0.0.1:4000", []string{"10.0.0.2"}, "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		req := &http.Request{RemoteAddr: tt.remoteAddr, Header: http.Header{}}
+		for _, h := range tt.forwardedFor {
+			req.Header.Add("X-Forwarded-For", h)
+		}
+		if got := rules.clientAddr(req); got != tt.want {
+			t.Errorf("%s: clientAddr() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+)
+
+const eaxBlockSize = 16
+
+// eax implements cipher.AEAD for AES in EAX mode with a 16-byte nonce and tag.
+// https://web.cs.ucdavis.edu/~rogaway/papers/eax.pdf
+type eax struct {
+	block  cipher.Block
+	k1, k2 [eaxBlockSize]byte // CMAC subkeys
+}
+
+func newEAX(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	e := &eax{block: block}
+	var l [eaxBlockSize]byte
+	block.Encrypt(l[:], l[:])
+	e.k1 = cmacDouble(l)
+	e.k2 = cmacDouble(e.k1)
+	return e, nil
+}
+
+func (e *eax) NonceSize() int { return eaxBlockSize }
+func (e *eax) Overhead() int  { return eaxBlockSize }
+
+func (e *eax) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	n := e.omac(0, nonce)
+	h := e.omac(1, additionalData)
+
+	ret, out := sliceForAppend(dst, len(plaintext)+eaxBlockSize)
+	cipher.NewCTR(e.block, n[:]).XORKeyStream(out, plaintext)
+	c := e.omac(2, out[:len(plaintext)])
+
+	tag := out[len(plaintext):]
+	for i := range tag {
+		tag[i] = n[i] ^ h[i] ^ c[i]
+	}
+	return ret
+}
+
+func (e *eax) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < eaxBlockSize {
+		return nil, errors.New("eax: ciphertext too short")
+	}
+	tag := ciphertext[len(ciphertext)-eaxBlockSize:]
+	ciphertext = ciphertext[:len(ciphertext)-eaxBlockSize]
+
+	n := e.omac(0, nonce)
+	h := e.omac(1, additionalData)
+	c := e.omac(2, ciphertext)
+	var expected [eaxBlockSize]byte
+	for i := range expected {
+		expected[i] = n[i] ^ h[i] ^ c[i]
+	}
+	if subtle.ConstantTimeCompare(expected[:], tag) != 1 {
+		return nil, errors.New("eax: message authentication failed")
+	}
+
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	cipher.NewCTR(e.block, n[:]).XORKeyStream(out, ciphertext)
+	return ret, nil
+}
+
+// omac computes the tweaked CMAC used by EAX: CMAC over a block holding t, followed by data.
+func (e *eax) omac(t byte, data []byte) [eaxBlockSize]byte {
+	var x, blk [eaxBlockSize]byte
+	blk[eaxBlockSize-1] = t
+
+	if len(data) == 0 {
+		// The tweak block is the final, complete block.
+		subtle.XORBytes(blk[:], blk[:], e.k1[:])
+		e.block.Encrypt(x[:], blk[:])
+		return x
+	}
+	e.block.Encrypt(x[:], blk[:])
+
+	for len(data) > eaxBlockSize {
+		subtle.XORBytes(x[:], x[:], data[:eaxBlockSize])
+		e.block.Encrypt(x[:], x[:])
+		data = data[eaxBlockSize:]
+	}
+
+	var last [eaxBlockSize]byte
+	copy(last[:], data)
+	if len(data) == eaxBlockSize {
+		subtle.XORBytes(last[:], last[:], e.k1[:])
+	} else {
+		last[len(data)] = 0x80
+		subtle.XORBytes(last[:], last[:], e.k2[:])
+	}
+	subtle.XORBytes(x[:], x[:], last[:])
+	e.block.Encrypt(x[:], x[:])
+	return x
+}
+
+// cmacDouble multiplies the block by x in GF(2^128).
+func cmacDouble(in [eaxBlockSize]byte) [eaxBlockSize]byte {
+	var out [eaxBlockSize]byte
+	carry := in[0] >> 7
+	for i := 0; i < eaxBlockSize-1; i++ {
+		out[i] = in[i]<<1 | in[i+1]>>7
+	}
+	out[eaxBlockSize-1] = in[eaxBlockSize-1] << 1
+	out[eaxBlockSize-1] ^= 0x87 * carry
+	return out
+}
+
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
+
+// aesEAXMaxMessage is the largest payload sent in a single message, matching TigerVNC.
+const aesEAXMaxMessage = 8192
+
+// aesEAXConn frames a connection the way the RSA-AES security types do. Every message is a
+// 16-bit length, the AES-EAX ciphertext and a 16-byte tag. The length is the associated
+// data, and each direction uses a little-endian message counter as the nonce.
+type aesEAXConn struct {
+	net.Conn
+	in, out           cipher.AEAD
+	inNonce, outNonce [eaxBlockSize]byte
+	pending           []byte // decrypted bytes not yet returned by Read
+}
+
+func newAESEAXConn(c net.Conn, inKey, outKey []byte) (*aesEAXConn, error) {
+	in, err := newEAX(inKey)
+	if err != nil {
+		return nil, err
+	}
+	out, err := newEAX(outKey)
+	if err != nil {
+		return nil, err
+	}
+	return &aesEAXConn{Conn: c, in: in, out: out}, nil
+}
+
+func (c *aesEAXConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		var hdr [2]byte
+		if _, err := io.ReadFull(c.Conn, hdr[:]); err != nil {
+			return 0, err
+		}
+		msg := make([]byte, int(binary.BigEndian.Uint16(hdr[:]))+eaxBlockSize)
+		if _, err := io.ReadFull(c.Conn, msg); err != nil {
+			return 0, err
+		}
+		plain, err := c.in.Open(msg[:0], c.inNonce[:], msg, hdr[:])
+		if err != nil {
+			return 0, err
+		}
+		incrementNonce(&c.inNonce)
+		c.pending = plain
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *aesEAXConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > aesEAXMaxMessage {
+			chunk = chunk[:aesEAXMaxMessage]
+		}
+		msg := make([]byte, 2, 2+len(chunk)+eaxBlockSize)
+		binary.BigEndian.PutUint16(msg, uint16(len(chunk)))
+		msg = c.out.Seal(msg, c.outNonce[:], chunk, msg[:2])
+		incrementNonce(&c.outNonce)
+		if _, err := c.Conn.Write(msg); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// incrementNonce increments the nonce as a 128-bit little-endian integer.
+func incrementNonce(n *[eaxBlockSize]byte) {
+	for i := range n {
+		n[i]++
+		if n[i] != 0 {
+			return
+		}
+	}
+}
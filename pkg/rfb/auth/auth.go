@@ -4,16 +4,23 @@ import (
 	"github.com/kamrankamilli/gsvnc/pkg/buffer"
 )
 
-// Type represents an authentication type.
+// Type represents an authentication type. Negotiate records anything it learns about the
+// client, such as who it authenticated as, on the given session.
 type Type interface {
 	Code() uint8
-	Negotiate(wr *buffer.ReadWriter) error
+	Negotiate(wr *buffer.ReadWriter, s *Session) error
 }
 
 // DefaultAuthTypes is the default enabled list of auth types.
 var DefaultAuthTypes = []Type{
+	&VeNCrypt{},
+	&RA256{},
+	&RA2{},
+	&RAne256{},
+	&RA2ne{},
 	&None{},
 	&VNCAuth{},
+	&ARD{},
 	&TightSecurity{},
 }
 
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/rand"
+	"errors"
+	"math/big"
+
+	"github.com/kamrankamilli/gsvnc/pkg/buffer"
+	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
+)
+
+// ardGenerator and ardPrime are the Diffie-Hellman group offered to clients, the 1024-bit
+// MODP group from RFC 2409.
+var (
+	ardGenerator = big.NewInt(2)
+	ardPrime, _  = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+		"29024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B"+
+		"302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B"+
+		"0BFF5CB6F406B7EDEE386BFB5A899FA5AE9F24117C4B1FE649286651ECE65381"+
+		"FFFFFFFFFFFFFFFF", 16)
+)
+
+// ardCredentialsLen is the size of the encrypted credentials block. The username and
+// password each take half of it, NUL terminated.
+const ardCredentialsLen = 128
+
+// ARD implements Apple Remote Desktop authentication, as preferred by macOS Screen Sharing.
+// A Diffie-Hellman key exchange yields an AES-128 key, which the client uses to encrypt a
+// username and password.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#diffie-hellman-authentication
+type ARD struct {
+	// Verifier checks the username and password.
+	Verifier CredentialVerifier
+	// Passwords are checked, whatever the username, when there is no Verifier.
+	Passwords
+}
+
+// Code returns the code.
+func (a *ARD) Code() uint8 { return 30 }
+
+// Negotiate will negotiate Apple Remote Desktop authentication.
+func (a *ARD) Negotiate(rw *buffer.ReadWriter, s *Session) error {
+	password, viewOnly := a.passwords()
+	if a.Verifier == nil && password == "" && viewOnly == "" {
+		return errors.New("no ARD credentials are configured")
+	}
+
+	keyLen := (ardPrime.BitLen() + 7) / 8
+	// The private key is picked from [2, p-2].
+	private, err := rand.Int(rand.Reader, new(big.Int).Sub(ardPrime, big.NewInt(3)))
+	if err != nil {
+		return err
+	}
+	private.Add(private, big.NewInt(2))
+	public := new(big.Int).Exp(ardGenerator, private, ardPrime)
+
+	buf := new(bytes.Buffer)
+	util.Write(buf, uint16(ardGenerator.Int64()))
+	util.Write(buf, uint16(keyLen))
+	util.Write(buf, ardPrime.FillBytes(make([]byte, keyLen)))
+	util.Write(buf, public.FillBytes(make([]byte, keyLen)))
+	rw.Dispatch(buf.Bytes())
+
+	credentials := make([]byte, ardCredentialsLen)
+	if err := rw.Read(credentials); err != nil {
+		return err
+	}
+	clientKey := make([]byte, keyLen)
+	if err := rw.Read(clientKey); err != nil {
+		return err
+	}
+	clientPublic := new(big.Int).SetBytes(clientKey)
+	if clientPublic.Cmp(big.NewInt(1)) <= 0 || clientPublic.Cmp(new(big.Int).Sub(ardPrime, big.NewInt(1))) >= 0 {
+		return errors.New("client sent an invalid Diffie-Hellman key")
+	}
+
+	// The credentials are encrypted with AES-128-ECB, keyed by the MD5 of the shared secret.
+	shared := new(big.Int).Exp(clientPublic, private, ardPrime).FillBytes(make([]byte, keyLen))
+	key := md5.Sum(shared)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(credentials); i += block.BlockSize() {
+		block.Decrypt(credentials[i:], credentials[i:])
+	}
+	username := cString(credentials[:ardCredentialsLen/2])
+	sent := cString(credentials[ardCredentialsLen/2:])
+
+	if a.Verifier != nil {
+		principal, err := a.Verifier.Verify(username, sent)
+		if err != nil {
+			return err
+		}
+		s.setPrincipal(principal)
+		return nil
+	}
+	return checkPassword(sent, password, viewOnly, s)
+}
+
+// cString returns the bytes up to the first NUL.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+)
+
+// ardClient does the client side of the Diffie-Hellman exchange, then sends the username
+// and password encrypted with the key it agreed.
+func ardClient(username, password string) func(c net.Conn) error {
+	return func(c net.Conn) error {
+		var hdr struct{ Generator, KeyLen uint16 }
+		if err := binary.Read(c, binary.BigEndian, &hdr); err != nil {
+			return err
+		}
+		keys := make([]byte, 2*int(hdr.KeyLen))
+		if _, err := io.ReadFull(c, keys); err != nil {
+			return err
+		}
+		prime := new(big.Int).SetBytes(keys[:hdr.KeyLen])
+		serverPublic := new(big.Int).SetBytes(keys[hdr.KeyLen:])
+		if prime.Cmp(ardPrime) != 0 || hdr.Generator != 2 {
+			return errors.New("unexpected Diffie-Hellman group")
+		}
+
+		private, err := rand.Int(rand.Reader, prime)
+		if err != nil {
+			return err
+		}
+		public := new(big.Int).Exp(big.NewInt(int64(hdr.Generator)), private, prime)
+		shared := new(big.Int).Exp(serverPublic, private, prime).FillBytes(make([]byte, hdr.KeyLen))
+		key := md5.Sum(shared)
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return err
+		}
+		credentials := make([]byte, ardCredentialsLen)
+		copy(credentials, username)
+		copy(credentials[ardCredentialsLen/2:], password)
+		for i := 0; i < len(credentials); i += block.BlockSize() {
+			block.Encrypt(credentials[i:], credentials[i:])
+		}
+
+		if _, err := c.Write(credentials); err != nil {
+			return err
+		}
+		_, err = c.Write(public.FillBytes(make([]byte, hdr.KeyLen)))
+		return err
+	}
+}
+
+func TestARDPasswords(t *testing.T) {
+	tests := []struct {
+		name         string
+		password     string
+		wantErr      bool
+		wantViewOnly bool
+	}{
+		{name: "full access", password: "secret"},
+		{name: "view-only", password: "watcher", wantViewOnly: true},
+		{name: "wrong password", password: "guess", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &ARD{Passwords: Passwords{Password: "secret", ViewOnlyPassword: "watcher"}}
+			s, err := negotiate(t, a, ardClient("anyone", tt.password))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Negotiate() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && s.ViewOnly != tt.wantViewOnly {
+				t.Errorf("ViewOnly = %v, want %v", s.ViewOnly, tt.wantViewOnly)
+			}
+		})
+	}
+}
+
+func TestARDVerifier(t *testing.T) {
+	a := &ARD{Verifier: StaticCredentials{"alice": "wonderland"}}
+	s, err := negotiate(t, a, ardClient("alice", "wonderland"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Principal == nil || s.Principal.Name != "alice" {
+		t.Errorf("Principal = %+v, want alice", s.Principal)
+	}
+
+	if _, err := negotiate(t, a, ardClient("alice", "secret")); err == nil {
+		t.Error("Negotiate() accepted the wrong password")
+	}
+}
+
+func TestARDRejectsInvalidKey(t *testing.T) {
+	a := &ARD{Passwords: Passwords{Password: "secret"}}
+	_, err := negotiate(t, a, func(c net.Conn) error {
+		keyLen := (ardPrime.BitLen() + 7) / 8
+		if _, err := io.ReadFull(c, make([]byte, 4+2*keyLen)); err != nil {
+			return err
+		}
+		// A public key of 1 would make the shared secret 1, whatever the server picked.
+		msg := make([]byte, ardCredentialsLen+keyLen)
+		msg[len(msg)-1] = 1
+		_, err := c.Write(msg)
+		return err
+	})
+	if err == nil {
+		t.Error("Negotiate() accepted a public key of 1")
+	}
+}
@@ -9,4 +9,4 @@ type None struct{}
 func (a *None) Code() uint8 { return 1 }
 
 // Negotiate immediately returns nil.
-func (a *None) Negotiate(rw *buffer.ReadWriter) error { return nil }
+func (a *None) Negotiate(rw *buffer.ReadWriter, s *Session) error { return nil }
@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kamrankamilli/gsvnc/pkg/buffer"
+	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
+)
+
+// RSA-AES credential subtypes.
+const (
+	rsaAESUserPass = 1
+	rsaAESPass     = 2
+)
+
+// Bounds on the client's RSA key size, in bits.
+const (
+	rsaAESMinKeyBits = 1024
+	rsaAESMaxKeyBits = 8192
+)
+
+// RSAAES holds the configuration shared by the RSA-AES security types. Both sides exchange
+// RSA public keys and encrypted randoms, from which the AES-EAX session keys are derived.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#rsa-aes-security-type
+type RSAAES struct {
+	// PrivateKey is the server key. Clients are shown its fingerprint to verify it.
+	PrivateKey *rsa.PrivateKey
+	// Passwords are checked when the client only sends a password.
+	Passwords
+	// Verifier checks username and password pairs. When set, clients are asked for both.
+	Verifier CredentialVerifier
+}
+
+// RSAAESConfig returns the shared configuration, so a server can set up any of the variants.
+func (r *RSAAES) RSAAESConfig() *RSAAES { return r }
+
+// RA2 is RSA-AES with 128-bit keys, encrypting the whole session.
+type RA2 struct{ RSAAES }
+
+// Code returns the code.
+func (r *RA2) Code() uint8 { return 5 }
+
+// Negotiate will negotiate RSA-AES.
+func (r *RA2) Negotiate(rw *buffer.ReadWriter, s *Session) error {
+	return r.negotiate(rw, s, 128, true)
+}
+
+// RA2ne is RSA-AES with 128-bit keys that only encrypts the authentication.
+type RA2ne struct{ RSAAES }
+
+// Code returns the code.
+func (r *RA2ne) Code() uint8 { return 6 }
+
+// Negotiate will negotiate RSA-AES.
+func (r *RA2ne) Negotiate(rw *buffer.ReadWriter, s *Session) error {
+	return r.negotiate(rw, s, 128, false)
+}
+
+// RA256 is RSA-AES with 256-bit keys, encrypting the whole session.
+type RA256 struct{ RSAAES }
+
+// Code returns the code.
+func (r *RA256) Code() uint8 { return 129 }
+
+// Negotiate will negotiate RSA-AES.
+func (r *RA256) Negotiate(rw *buffer.ReadWriter, s *Session) error {
+	return r.negotiate(rw, s, 256, true)
+}
+
+// RAne256 is RSA-AES with 256-bit keys that only encrypts the authentication.
+type RAne256 struct{ RSAAES }
+
+// Code returns the code.
+func (r *RAne256) Code() uint8 { return 130 }
+
+// Negotiate will negotiate RSA-AES.
+func (r *RAne256) Negotiate(rw *buffer.ReadWriter, s *Session) error {
+	return r.negotiate(rw, s, 256, false)
+}
+
+func (r *RSAAES) negotiate(rw *buffer.ReadWriter, s *Session, keySize int, allEncrypted bool) error {
+	if r.PrivateKey == nil {
+		return errors.New("no RSA server key is configured")
+	}
+	full, viewOnly := r.passwords()
+	if r.Verifier == nil && full == "" && viewOnly == "" {
+		return errors.New("no RSA-AES credentials are configured")
+	}
+
+	// Public key exchange
+	serverKey := encodeRSAPublicKey(&r.PrivateKey.PublicKey)
+	rw.Dispatch(serverKey)
+	clientPub, clientKey, err := readRSAPublicKey(rw)
+	if err != nil {
+		return err
+	}
+
+	// Random exchange
+	serverRandom := make([]byte, keySize/8)
+	if _, err := rand.Read(serverRandom); err != nil {
+		return err
+	}
+	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, clientPub, serverRandom)
+	if err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	util.Write(buf, uint16(len(encrypted)))
+	util.Write(buf, encrypted)
+	rw.Dispatch(buf.Bytes())
+
+	var size uint16
+	if err := rw.Read(&size); err != nil {
+		return err
+	}
+	if int(size) != r.PrivateKey.Size() {
+		return fmt.Errorf("client random has invalid length %d", size)
+	}
+	encrypted = make([]byte, size)
+	if err := rw.Read(encrypted); err != nil {
+		return err
+	}
+	clientRandom, err := rsa.DecryptPKCS1v15(nil, r.PrivateKey, encrypted)
+	if err != nil {
+		return err
+	}
+	if len(clientRandom) != keySize/8 {
+		return fmt.Errorf("client random has invalid length %d", len(clientRandom))
+	}
+
+	// Everything from here on is AES-EAX framed.
+	newHash := sha1.New
+	if keySize == 256 {
+		newHash = sha256.New
+	}
+	inKey := digest(newHash, keySize/8, clientRandom, serverRandom)
+	outKey := digest(newHash, keySize/8, serverRandom, clientRandom)
+	var eaxConn *aesEAXConn
+	if err := rw.Upgrade(func(c net.Conn) (net.Conn, error) {
+		eaxConn, err = newAESEAXConn(c, inKey, outKey)
+		return eaxConn, err
+	}); err != nil {
+		return err
+	}
+
+	// Prove to each other that the keys were not swapped in transit.
+	hashSize := newHash().Size()
+	rw.Dispatch(digest(newHash, hashSize, serverKey, clientKey))
+	clientHash := make([]byte, hashSize)
+	if err := rw.Read(clientHash); err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(clientHash, digest(newHash, hashSize, clientKey, serverKey)) != 1 {
+		return errors.New("client key hash does not match")
+	}
+
+	// Credentials
+	subtype := uint8(rsaAESPass)
+	if r.Verifier != nil {
+		subtype = rsaAESUserPass
+	}
+	rw.Dispatch([]byte{subtype})
+	username, err := readRSAAESCredential(rw)
+	if err != nil {
+		return err
+	}
+	password, err := readRSAAESCredential(rw)
+	if err != nil {
+		return err
+	}
+
+	if !allEncrypted {
+		// Drop back to the raw connection for the rest of the session.
+		if err := rw.Upgrade(func(net.Conn) (net.Conn, error) { return eaxConn.Conn, nil }); err != nil {
+			return err
+		}
+	}
+
+	if subtype == rsaAESUserPass {
+		principal, err := r.Verifier.Verify(username, password)
+		if err != nil {
+			return err
+		}
+		s.setPrincipal(principal)
+		return nil
+	}
+	return checkPassword(password, full, viewOnly, s)
+}
+
+// encodeRSAPublicKey returns the wire format of a public key: its size in bits, followed by
+// the modulus and exponent, each padded to the size of the modulus.
+func encodeRSAPublicKey(pub *rsa.PublicKey) []byte {
+	size := pub.Size()
+	out := make([]byte, 4+2*size)
+	binary.BigEndian.PutUint32(out, uint32(size*8))
+	pub.N.FillBytes(out[4 : 4+size])
+	big.NewInt(int64(pub.E)).FillBytes(out[4+size:])
+	return out
+}
+
+func readRSAPublicKey(rw *buffer.ReadWriter) (*rsa.PublicKey, []byte, error) {
+	var bits uint32
+	if err := rw.Read(&bits); err != nil {
+		return nil, nil, err
+	}
+	if bits < rsaAESMinKeyBits || bits > rsaAESMaxKeyBits {
+		return nil, nil, fmt.Errorf("client RSA key has unsupported size %d", bits)
+	}
+	size := int(bits+7) / 8
+	key := make([]byte, 4+2*size)
+	binary.BigEndian.PutUint32(key, bits)
+	if err := rw.Read(key[4:]); err != nil {
+		return nil, nil, err
+	}
+	e := new(big.Int).SetBytes(key[4+size:])
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, nil, errors.New("client RSA key has an invalid exponent")
+	}
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(key[4 : 4+size]), E: int(e.Int64())}
+	return pub, key, nil
+}
+
+func readRSAAESCredential(rw *buffer.ReadWriter) (string, error) {
+	var l uint8
+	if err := rw.Read(&l); err != nil {
+		return "", err
+	}
+	b := make([]byte, l)
+	if err := rw.Read(b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func digest(newHash func() hash.Hash, size int, parts ...[]byte) []byte {
+	h := newHash()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)[:size]
+}
+
+// RSAKeyFingerprint returns the fingerprint of a server key in the form viewers such as
+// TigerVNC display it when asking the user to verify the server.
+func RSAKeyFingerprint(pub *rsa.PublicKey) string {
+	sum := sha1.Sum(encodeRSAPublicKey(pub))
+	parts := make([]string, 8)
+	for i := range parts {
+		parts[i] = fmt.Sprintf("%02x", sum[i])
+	}
+	return strings.Join(parts, "-")
+}
+
+// LoadOrGenerateRSAKey reads a PEM encoded RSA private key from path. If the file does not
+// exist a new key of the given size is generated and written there.
+func LoadOrGenerateRSAKey(path string, bits int) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		out := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+		return key, os.WriteFile(path, out, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM encoded key", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s does not contain an RSA key", path)
+	}
+	return key, nil
+}
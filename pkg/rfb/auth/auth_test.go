@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"net"
+	"testing"
+
+	"github.com/kamrankamilli/gsvnc/pkg/buffer"
+)
+
+// negotiate runs a security type's server side against client, on the other end of a pipe.
+func negotiate(t *testing.T, typ Type, client func(c net.Conn) error) (*Session, error) {
+	t.Helper()
+	a, b := net.Pipe()
+	rw := buffer.NewReadWriteBuffer(a)
+	clientErr := make(chan error, 1)
+	go func() { clientErr <- client(b) }()
+
+	s := &Session{}
+	err := typ.Negotiate(rw, s)
+	if err == nil {
+		// The server has read everything, so the client is done.
+		if cerr := <-clientErr; cerr != nil {
+			t.Fatal("client: ", cerr)
+		}
+	}
+	rw.Close()
+	a.Close()
+	b.Close()
+	return s, err
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 
@@ -19,56 +20,106 @@ var TightTunnelCapabilities = []types.TightCapability{
 var TightAuthCapabilities = []types.TightCapability{
 	{Code: 1, Vendor: "STDV", Signature: "NOAUTH__"},
 	{Code: 2, Vendor: "STDV", Signature: "VNCAUTH_"},
+	{Code: tightUnixLoginAuth, Vendor: "TGHT", Signature: "ULGNAUTH"},
 }
 
-// TightServerMessages represents supported tight server messages.
-var TightServerMessages = []types.TightCapability{}
+// tightUnixLoginAuth is the Tight auth code for username/password logins. It is handled by
+// TightSecurity itself, since the code clashes with the RA256 security type.
+const tightUnixLoginAuth = 129
 
-// TightClientMessages represents supported tight client messages.
-var TightClientMessages = []types.TightCapability{}
+// TightServerMessages lists the known Tight server message capabilities. Only those sent
+// by a message extension enabled on the server are advertised. Fences and extended
+// clipboard replies have none, clients find out about them from pseudo-encodings.
+var TightServerMessages = []types.TightCapability{
+	{Code: 150, Vendor: "TGHT", Signature: "CUS_EOCU"},
+}
+
+// TightClientMessages lists the known Tight client message capabilities. Only those with
+// a handler enabled on the server are advertised. As with server messages, fences and
+// SetDesktopSize have none.
+var TightClientMessages = []types.TightCapability{
+	{Code: 150, Vendor: "TGHT", Signature: "CUC_ENCU"},
+}
 
-// TightEncodingCapabilities represents TightSecurity encoding capabilities.
+// TightEncodingCapabilities lists the known Tight encoding capabilities, including
+// pseudo-encodings. Only those supported on the server are advertised.
 var TightEncodingCapabilities = []types.TightCapability{
 	{Code: 0, Vendor: "STDV", Signature: "RAW_____"},
 	{Code: 1, Vendor: "STDV", Signature: "COPYRECT"},
+	{Code: 2, Vendor: "STDV", Signature: "RRE_____"},
+	{Code: 4, Vendor: "STDV", Signature: "CORRE___"},
+	{Code: 5, Vendor: "STDV", Signature: "HEXTILE_"},
+	{Code: 6, Vendor: "TRDV", Signature: "ZLIB____"},
 	{Code: 7, Vendor: "TGHT", Signature: "TIGHT___"},
+	{Code: 8, Vendor: "TRDV", Signature: "ZLIBHEX_"},
+	{Code: 16, Vendor: "TRDV", Signature: "ZRLE____"},
+	{Code: -256, Vendor: "TGHT", Signature: "COMPRLVL"},
+	{Code: -32, Vendor: "TGHT", Signature: "JPEGQLVL"},
+	{Code: -223, Vendor: "TGHT", Signature: "NEWFBSIZ"},
+	{Code: -239, Vendor: "TGHT", Signature: "RCHCURSR"},
+	{Code: -240, Vendor: "TGHT", Signature: "X11CURSR"},
 }
 
 // TightSecurity implements Tight security.
 // https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#tight-security-type
 type TightSecurity struct {
 	AuthGetter func(code uint8) Type
+	// ServerMessages, ClientMessages and Encodings are the codes of the server messages,
+	// client messages, encodings and pseudo-encodings enabled on the server. The matching capabilities are
+	// advertised in the ServerInit message.
+	ServerMessages []int32
+	ClientMessages []int32
+	Encodings      []int32
+	// Verifier checks Unix login credentials. The capability is not offered when it is nil.
+	Verifier CredentialVerifier
 }
 
 // Code returns the code.
 func (t *TightSecurity) Code() uint8 { return 16 }
 
 // Negotiate will negotiate tight security.
-func (t *TightSecurity) Negotiate(rw *buffer.ReadWriter) error {
+func (t *TightSecurity) Negotiate(rw *buffer.ReadWriter, s *Session) error {
 	if err := t.negotiateTightTunnel(rw); err != nil {
 		return err
 	}
-	return t.negotiateTightAuth(rw)
+	return t.negotiateTightAuth(rw, s)
 }
 
 // ExtendServerInit signals to the rfb server that we extend the ServerInit message.
 func (t *TightSecurity) ExtendServerInit(buf io.Writer) {
-	util.Write(buf, uint16(len(TightServerMessages)))
-	util.Write(buf, uint16(len(TightClientMessages)))
-	util.Write(buf, uint16(len(TightEncodingCapabilities)))
+	serverMsgs := filterTightCaps(TightServerMessages, t.ServerMessages)
+	clientMsgs := filterTightCaps(TightClientMessages, t.ClientMessages)
+	encs := filterTightCaps(TightEncodingCapabilities, t.Encodings)
+	util.Write(buf, uint16(len(serverMsgs)))
+	util.Write(buf, uint16(len(clientMsgs)))
+	util.Write(buf, uint16(len(encs)))
 	util.Write(buf, uint8(0)) // padding
 	util.Write(buf, uint8(0)) // padding
-	for _, cap := range TightServerMessages {
+	for _, cap := range serverMsgs {
 		util.PackStruct(buf, &cap)
 	}
-	for _, cap := range TightClientMessages {
+	for _, cap := range clientMsgs {
 		util.PackStruct(buf, &cap)
 	}
-	for _, cap := range TightEncodingCapabilities {
+	for _, cap := range encs {
 		util.PackStruct(buf, &cap)
 	}
 }
 
+// filterTightCaps returns the capabilities whose code is in codes, in the order of codes.
+func filterTightCaps(known []types.TightCapability, codes []int32) []types.TightCapability {
+	out := make([]types.TightCapability, 0)
+	for _, code := range codes {
+		for _, cap := range known {
+			if cap.Code == code {
+				out = append(out, cap)
+				break
+			}
+		}
+	}
+	return out
+}
+
 func (t *TightSecurity) negotiateTightTunnel(rw *buffer.ReadWriter) error {
 	// Write the supported tunnel capabilities to the client
 	buf := new(bytes.Buffer)
@@ -78,20 +129,25 @@ func (t *TightSecurity) negotiateTightTunnel(rw *buffer.ReadWriter) error {
 	}
 	rw.Dispatch(buf.Bytes())
 
+	// The client only picks a tunnel if any were offered.
+	if len(TightTunnelCapabilities) == 0 {
+		return nil
+	}
+
 	// get the desired tunnel type from the client
 	var tun int32
-	rw.Read(&tun)
-
-	// We only support no tunneling for now, client should know
-	// better
-	if tun != 0 {
-		return fmt.Errorf("client requested unsupported tunnel type: %d", tun)
+	if err := rw.Read(&tun); err != nil {
+		return err
 	}
-
-	return nil
+	for _, cap := range TightTunnelCapabilities {
+		if cap.Code == tun {
+			return nil
+		}
+	}
+	return fmt.Errorf("client requested unsupported tunnel type: %d", tun)
 }
 
-func (t *TightSecurity) negotiateTightAuth(rw *buffer.ReadWriter) error {
+func (t *TightSecurity) negotiateTightAuth(rw *buffer.ReadWriter, s *Session) error {
 	buf := new(bytes.Buffer)
 	caps := t.getEnabledAuthCaps()
 	util.Write(buf, uint32(len(caps)))
@@ -100,21 +156,39 @@ func (t *TightSecurity) negotiateTightAuth(rw *buffer.ReadWriter) error {
 	}
 	rw.Dispatch(buf.Bytes())
 
-	// get the desired auth type, should be none
+	// The client skips authentication when offered nothing, so refuse instead.
+	if len(caps) == 0 {
+		return errors.New("no Tight auth capabilities are available")
+	}
+
+	// get the desired auth type
 	var auth int32
-	rw.Read(&auth)
+	if err := rw.Read(&auth); err != nil {
+		return err
+	}
 
-	authType := t.AuthGetter(uint8(auth))
+	if auth == tightUnixLoginAuth && t.Verifier != nil {
+		return negotiatePlain(rw, t.Verifier, s)
+	}
+	authType := t.getAuth(auth)
 	if authType == nil {
 		return fmt.Errorf("client requested unsupported tight auth type: %d", auth)
 	}
-	return authType.Negotiate(rw)
+	return authType.Negotiate(rw, s)
+}
+
+// getAuth returns the security type backing a Tight auth capability.
+func (t *TightSecurity) getAuth(code int32) Type {
+	if code == tightUnixLoginAuth {
+		return nil
+	}
+	return t.AuthGetter(uint8(code))
 }
 
 func (t *TightSecurity) getEnabledAuthCaps() []types.TightCapability {
 	enabledCaps := make([]types.TightCapability, 0)
 	for _, cap := range TightAuthCapabilities {
-		if t := t.AuthGetter(uint8(cap.Code)); t != nil {
+		if cap.Code == tightUnixLoginAuth && t.Verifier != nil || t.getAuth(cap.Code) != nil {
 			enabledCaps = append(enabledCaps, cap)
 		}
 	}
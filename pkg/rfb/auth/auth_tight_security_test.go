@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// readTightCaps reads a count of capabilities and returns their codes.
+func readTightCaps(r io.Reader) ([]int32, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	codes := make([]int32, n)
+	for i := range codes {
+		var c struct {
+			Code      int32
+			Signature [12]byte
+		}
+		if err := binary.Read(r, binary.BigEndian, &c); err != nil {
+			return nil, err
+		}
+		codes[i] = c.Code
+	}
+	return codes, nil
+}
+
+func TestTightSecurityTunnel(t *testing.T) {
+	tests := []struct {
+		name    string
+		tunnel  int32
+		wantErr bool
+	}{
+		{name: "no tunnel", tunnel: 0},
+		{name: "unknown tunnel", tunnel: 1, wantErr: true},
+		{name: "negative tunnel", tunnel: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &TightSecurity{AuthGetter: func(code uint8) Type {
+				if code == 1 {
+					return &None{}
+				}
+				return nil
+			}}
+			_, err := negotiate(t, a, func(c net.Conn) error {
+				tunnels, err := readTightCaps(c)
+				if err != nil {
+					return err
+				}
+				if len(tunnels) != 1 || tunnels[0] != 0 {
+					t.Errorf("tunnels offered = %v, want only NOTUNNEL", tunnels)
+				}
+				if err := binary.Write(c, binary.BigEndian, tt.tunnel); err != nil {
+					return err
+				}
+				if _, err := readTightCaps(c); err != nil {
+					return err
+				}
+				return binary.Write(c, binary.BigEndian, int32(1))
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Negotiate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
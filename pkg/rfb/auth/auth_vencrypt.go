@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kamrankamilli/gsvnc/pkg/buffer"
+	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
+	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
+)
+
+// VeNCrypt subtypes.
+const (
+	VeNCryptTLSNone   uint32 = 257
+	VeNCryptTLSVnc    uint32 = 258
+	VeNCryptTLSPlain  uint32 = 259
+	VeNCryptX509None  uint32 = 260
+	VeNCryptX509Vnc   uint32 = 261
+	VeNCryptX509Plain uint32 = 262
+)
+
+// DefaultVeNCryptSubtypes is the order in which VeNCrypt subtypes are offered to clients.
+var DefaultVeNCryptSubtypes = []uint32{
+	VeNCryptX509Plain,
+	VeNCryptX509Vnc,
+	VeNCryptX509None,
+	VeNCryptTLSPlain,
+	VeNCryptTLSVnc,
+	VeNCryptTLSNone,
+}
+
+var veNCryptSubtypeNames = map[uint32]string{
+	VeNCryptTLSNone:   "TLSNone",
+	VeNCryptTLSVnc:    "TLSVnc",
+	VeNCryptTLSPlain:  "TLSPlain",
+	VeNCryptX509None:  "X509None",
+	VeNCryptX509Vnc:   "X509Vnc",
+	VeNCryptX509Plain: "X509Plain",
+}
+
+// VeNCrypt implements the VeNCrypt security type. The session is wrapped in TLS once a
+// subtype has been chosen, after which the subtype's own authentication runs inside it.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#vencrypt
+//
+// crypto/tls has no anonymous cipher suites, so the TLS* subtypes are served with the
+// same certificate as the X509* ones.
+type VeNCrypt struct {
+	// TLSConfig is used to wrap the connection. It must carry a certificate.
+	TLSConfig *tls.Config
+	// Subtypes restricts and orders the offered subtypes. Defaults to DefaultVeNCryptSubtypes.
+	Subtypes []uint32
+	// AuthGetter resolves the None and VNCAuth handlers used inside the TLS session.
+	AuthGetter func(code uint8) Type
+	// Verifier checks the credentials sent with the Plain subtypes. The Plain subtypes
+	// are not offered when it is nil.
+	Verifier CredentialVerifier
+}
+
+// Code returns the code.
+func (v *VeNCrypt) Code() uint8 { return 19 }
+
+// Negotiate will negotiate VeNCrypt and the chosen subtype.
+func (v *VeNCrypt) Negotiate(rw *buffer.ReadWriter, s *Session) error {
+	// Version 0.2 is the only one in use.
+	rw.Dispatch([]byte{0, 2})
+	var major, minor uint8
+	if err := rw.Read(&major); err != nil {
+		return err
+	}
+	if err := rw.Read(&minor); err != nil {
+		return err
+	}
+	if major != 0 || minor != 2 {
+		rw.Dispatch([]byte{0xff})
+		return fmt.Errorf("client requested unsupported VeNCrypt version %d.%d", major, minor)
+	}
+
+	subtypes := v.enabledSubtypes()
+	buf := new(bytes.Buffer)
+	util.Write(buf, uint8(0)) // version ok
+	util.Write(buf, uint8(len(subtypes)))
+	for _, st := range subtypes {
+		util.Write(buf, st)
+	}
+	rw.Dispatch(buf.Bytes())
+	if len(subtypes) == 0 {
+		return errors.New("no VeNCrypt subtypes are available")
+	}
+
+	var wanted uint32
+	if err := rw.Read(&wanted); err != nil {
+		return err
+	}
+	if !containsSubtype(subtypes, wanted) {
+		rw.Dispatch([]byte{0})
+		return fmt.Errorf("client requested unsupported VeNCrypt subtype %d", wanted)
+	}
+	log.Info("Using VeNCrypt subtype: ", veNCryptSubtypeNames[wanted])
+
+	// Every subtype we offer is TLS wrapped, signal the client to start the handshake.
+	rw.Dispatch([]byte{1})
+	if err := rw.Upgrade(v.startTLS); err != nil {
+		return fmt.Errorf("TLS handshake failed: %w", err)
+	}
+
+	switch wanted {
+	case VeNCryptTLSNone, VeNCryptX509None:
+		return v.AuthGetter((&None{}).Code()).Negotiate(rw, s)
+	case VeNCryptTLSVnc, VeNCryptX509Vnc:
+		return v.AuthGetter((&VNCAuth{}).Code()).Negotiate(rw, s)
+	default:
+		return negotiatePlain(rw, v.Verifier, s)
+	}
+}
+
+func (v *VeNCrypt) startTLS(c net.Conn) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tc := tls.Server(c, v.TLSConfig)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+func (v *VeNCrypt) enabledSubtypes() []uint32 {
+	subtypes := v.Subtypes
+	if len(subtypes) == 0 {
+		subtypes = DefaultVeNCryptSubtypes
+	}
+	enabled := make([]uint32, 0, len(subtypes))
+	if v.TLSConfig == nil {
+		return enabled
+	}
+	for _, st := range subtypes {
+		var ok bool
+		switch st {
+		case VeNCryptTLSNone, VeNCryptX509None:
+			ok = v.AuthGetter((&None{}).Code()) != nil
+		case VeNCryptTLSVnc, VeNCryptX509Vnc:
+			ok = v.AuthGetter((&VNCAuth{}).Code()) != nil
+		case VeNCryptTLSPlain, VeNCryptX509Plain:
+			ok = v.Verifier != nil
+		}
+		if ok {
+			enabled = append(enabled, st)
+		}
+	}
+	return enabled
+}
+
+func containsSubtype(subtypes []uint32, st uint32) bool {
+	for _, s := range subtypes {
+		if s == st {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseVeNCryptSubtypes parses a comma-separated list of subtype names (e.g. "X509Vnc,TLSVnc").
+func ParseVeNCryptSubtypes(list string) ([]uint32, error) {
+	out := make([]uint32, 0)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		var found bool
+		for code, n := range veNCryptSubtypeNames {
+			if strings.EqualFold(n, name) {
+				out = append(out, code)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown VeNCrypt subtype: %s", name)
+		}
+	}
+	return out, nil
+}
+
+// NewSelfSignedTLSConfig generates a throwaway self-signed certificate and returns a TLS
+// config serving it.
+func NewSelfSignedTLSConfig() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "localhost"
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname, Organization: []string{"gsvnc"}},
+		DNSNames:              []string{hostname},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}, nil
+}
+
+// CertificateFingerprint returns the colon-separated SHA-256 fingerprint of the first
+// certificate in the given config, or an empty string if there is none.
+func CertificateFingerprint(cfg *tls.Config) string {
+	if cfg == nil || len(cfg.Certificates) == 0 || len(cfg.Certificates[0].Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(cfg.Certificates[0].Certificate[0])
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
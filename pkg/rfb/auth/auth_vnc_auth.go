@@ -1,8 +1,8 @@
 package auth
 
 import (
-	"crypto/des"
 	"crypto/rand"
+	"crypto/subtle"
 	"errors"
 
 	"github.com/kamrankamilli/gsvnc/pkg/buffer"
@@ -10,55 +10,65 @@ import (
 
 // VNCAuth represents VNCAuthentication.
 type VNCAuth struct {
-	Password string
+	Passwords
 }
 
 // Code returns the code for vnc uth.
 func (a *VNCAuth) Code() uint8 { return 2 }
 
-// Negotiate immediately returns nil.
-func (a *VNCAuth) Negotiate(rw *buffer.ReadWriter) error {
-	key := a.Password
-	keyBytes := []byte{0, 0, 0, 0, 0, 0, 0, 0}
-
-	if len(key) > 8 {
-		key = key[:8]
+// Negotiate sends a challenge and checks the client's response against the passwords.
+func (a *VNCAuth) Negotiate(rw *buffer.ReadWriter, s *Session) error {
+	password, viewOnly := a.passwords()
+	if password == "" {
+		return errors.New("no VNC password is configured")
 	}
 
-	for i := 0; i < len(key); i++ {
-		keyBytes[i] = a.reverseBits(key[i])
+	challenge := make([]byte, 16)
+	if _, err := rand.Read(challenge); err != nil {
+		return err
 	}
 
-	block, err := des.NewCipher(keyBytes)
-	if err != nil {
+	rw.Dispatch(challenge)
+
+	response := make([]byte, 16)
+	if err := rw.Read(response); err != nil {
 		return err
 	}
 
-	challenge := make([]byte, 16)
-	_, err = rand.Read(challenge)
-	if err != nil {
+	ok, err := a.checkResponse(password, challenge, response)
+	if err != nil || ok {
 		return err
 	}
+	if viewOnly != "" {
+		ok, err = a.checkResponse(viewOnly, challenge, response)
+		if err != nil {
+			return err
+		}
+		if ok {
+			s.ViewOnly = true
+			return nil
+		}
+	}
 
-	rw.Dispatch(challenge)
+	return errors.New("Password is invalid")
+}
 
-	res := make([]byte, 0)
-	for i := 0; i < 2; i++ {
-		b := make([]byte, 8)
-		rw.Read(b)
-		decrypted := make([]byte, 8)
-		block.Decrypt(decrypted, b)
-		res = append(res, decrypted...)
+// checkResponse returns true if response is the challenge encrypted with the password.
+func (a *VNCAuth) checkResponse(password string, challenge, response []byte) (bool, error) {
+	block, err := newVNCCipher([]byte(password))
+	if err != nil {
+		return false, err
 	}
 
-	if string(res) != string(challenge) {
-		return errors.New("Password is invalid")
+	expected := make([]byte, len(challenge))
+	for i := 0; i < len(challenge); i += block.BlockSize() {
+		block.Encrypt(expected[i:], challenge[i:])
 	}
 
-	return nil
+	return subtle.ConstantTimeCompare(expected, response) == 1, nil
 }
 
-func (a *VNCAuth) reverseBits(b byte) byte {
+func reverseBits(b byte) byte {
 	var reverse = [256]int{
 		0, 128, 64, 192, 32, 160, 96, 224,
 		16, 144, 80, 208, 48, 176, 112, 240,
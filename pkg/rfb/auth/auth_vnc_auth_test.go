@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/des"
+	"io"
+	"net"
+	"testing"
+)
+
+// vncAuthClient answers the challenge the way a VNC viewer does, DES encrypting it with the
+// password as the key, each key byte bit reversed.
+func vncAuthClient(password string) func(c net.Conn) error {
+	return func(c net.Conn) error {
+		challenge := make([]byte, 16)
+		if _, err := io.ReadFull(c, challenge); err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		copy(key, password)
+		for i, b := range key {
+			var r byte
+			for bit := 0; bit < 8; bit++ {
+				r |= (b >> bit & 1) << (7 - bit)
+			}
+			key[i] = r
+		}
+		block, err := des.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		response := make([]byte, 16)
+		block.Encrypt(response, challenge)
+		block.Encrypt(response[8:], challenge[8:])
+		_, err = c.Write(response)
+		return err
+	}
+}
+
+func TestVNCAuth(t *testing.T) {
+	tests := []struct {
+		name         string
+		password     string
+		wantErr      bool
+		wantViewOnly bool
+	}{
+		{name: "full access", password: "secret"},
+		{name: "view-only", password: "watcher", wantViewOnly: true},
+		{name: "wrong password", password: "guess", wantErr: true},
+		// Only the first 8 characters of a password are used.
+		{name: "long password", password: "secretlongerthan8", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &VNCAuth{Passwords: Passwords{Password: "secret", ViewOnlyPassword: "watcher"}}
+			s, err := negotiate(t, a, vncAuthClient(tt.password))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Negotiate() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && s.ViewOnly != tt.wantViewOnly {
+				t.Errorf("ViewOnly = %v, want %v", s.ViewOnly, tt.wantViewOnly)
+			}
+		})
+	}
+}
+
+func TestVNCAuthTruncatesPassword(t *testing.T) {
+	a := &VNCAuth{Passwords: Passwords{Password: "12345678"}}
+	if _, err := negotiate(t, a, vncAuthClient("123456789")); err != nil {
+		t.Errorf("Negotiate() error = %v, want the password cut to 8 characters", err)
+	}
+}
+
+func TestVNCAuthWithoutPassword(t *testing.T) {
+	a := &VNCAuth{}
+	if _, err := negotiate(t, a, func(c net.Conn) error { return nil }); err == nil {
+		t.Error("Negotiate() succeeded with no password configured")
+	}
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/kamrankamilli/gsvnc/pkg/buffer"
+)
+
+// ErrInvalidCredentials is returned by verifiers when a username or password is wrong.
+var ErrInvalidCredentials = errors.New("Username or password is invalid")
+
+// Principal identifies an authenticated client.
+type Principal struct {
+	Name string
+	// ViewOnly is set for users who may watch the session but not send input.
+	ViewOnly bool
+}
+
+// Session holds what a connection established during security negotiation.
+type Session struct {
+	// Principal is who the client authenticated as. It is nil for security types
+	// without a notion of users.
+	Principal *Principal
+	// ViewOnly is set when the client may watch the session but not send input.
+	ViewOnly bool
+}
+
+// setPrincipal records an authenticated principal along with the access it grants.
+func (s *Session) setPrincipal(p *Principal) {
+	s.Principal = p
+	s.ViewOnly = s.ViewOnly || p.ViewOnly
+}
+
+// CredentialVerifier checks username and password pairs sent by clients.
+type CredentialVerifier interface {
+	Verify(username, password string) (*Principal, error)
+}
+
+// StaticCredentials is a CredentialVerifier backed by a map of usernames to passwords.
+type StaticCredentials map[string]string
+
+// Verify checks the password for the given user.
+func (s StaticCredentials) Verify(username, password string) (*Principal, error) {
+	expected, ok := s[username]
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(password)) != 1 || !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{Name: username}, nil
+}
+
+// Htpasswd is a CredentialVerifier backed by an htpasswd-style file of bcrypt hashes,
+// as written by `htpasswd -B`. A line may carry a third field, "viewonly" or "full",
+// giving the user's access (e.g. `alice:$2y$05$...:viewonly`). Users default to full access.
+type Htpasswd struct {
+	users map[string]htpasswdUser
+}
+
+type htpasswdUser struct {
+	hash     []byte
+	viewOnly bool
+}
+
+// dummyHash is compared against for unknown users so they take as long as known ones.
+var dummyHash = []byte("$2a$10$nHriYAUl3xZ6NuQ7K/YQcuVNe9X.FDyu7np5B.EWcTMaf2ZLKfD.2")
+
+// LoadHtpasswd reads an htpasswd file. Blank lines and lines starting with # are ignored.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := &Htpasswd{users: make(map[string]htpasswdUser)}
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:hash[:role]", path, lineno)
+		}
+		if _, err := bcrypt.Cost([]byte(fields[1])); err != nil {
+			return nil, fmt.Errorf("%s:%d: only bcrypt hashes are supported", path, lineno)
+		}
+		user := htpasswdUser{hash: []byte(fields[1])}
+		if len(fields) == 3 {
+			switch fields[2] {
+			case "viewonly":
+				user.viewOnly = true
+			case "full":
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown role %q", path, lineno, fields[2])
+			}
+		}
+		h.users[fields[0]] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Verify checks the password for the given user.
+func (h *Htpasswd) Verify(username, password string) (*Principal, error) {
+	user, ok := h.users[username]
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword(user.hash, []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{Name: username, ViewOnly: user.viewOnly}, nil
+}
+
+// maxPlainCredentialLen bounds the username and password sizes accepted by negotiatePlain.
+const maxPlainCredentialLen = 1024
+
+// negotiatePlain reads a username and password, each preceded by a 32-bit length, as
+// sent by VeNCrypt Plain and Tight's Unix login authentication.
+func negotiatePlain(rw *buffer.ReadWriter, verifier CredentialVerifier, s *Session) error {
+	var ulen, plen uint32
+	if err := rw.Read(&ulen); err != nil {
+		return err
+	}
+	if err := rw.Read(&plen); err != nil {
+		return err
+	}
+	if ulen > maxPlainCredentialLen || plen > maxPlainCredentialLen {
+		return errors.New("Plain credentials are too long")
+	}
+	username := make([]byte, ulen)
+	if err := rw.Read(username); err != nil {
+		return err
+	}
+	password := make([]byte, plen)
+	if err := rw.Read(password); err != nil {
+		return err
+	}
+	principal, err := verifier.Verify(string(username), string(password))
+	if err != nil {
+		return err
+	}
+	s.setPrincipal(principal)
+	return nil
+}
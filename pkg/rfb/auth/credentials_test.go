@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeHtpasswd writes an htpasswd file and returns its path.
+func writeHtpasswd(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func bcryptHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestLoadHtpasswd(t *testing.T) {
+	alice, bob, carol := bcryptHash(t, "wonderland"), bcryptHash(t, "builder"), bcryptHash(t, "singer")
+	path := writeHtpasswd(t, "# users\n\n"+
+		"alice:"+alice+"\n"+
+		"  bob:"+bob+":viewonly  \r\n"+
+		"carol:"+carol+":full\n")
+	h, err := LoadHtpasswd(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		username, password string
+		wantErr            bool
+		wantViewOnly       bool
+	}{
+		{username: "alice", password: "wonderland"},
+		{username: "bob", password: "builder", wantViewOnly: true},
+		{username: "carol", password: "singer"},
+		{username: "alice", password: "builder", wantErr: true},
+		{username: "alice", password: "", wantErr: true},
+		{username: "dave", password: "wonderland", wantErr: true},
+		{username: "", password: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		p, err := h.Verify(tt.username, tt.password)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Verify(%q, %q) error = %v, want ErrInvalidCredentials", tt.username, tt.password, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Verify(%q, %q) error = %v", tt.username, tt.password, err)
+			continue
+		}
+		if p.Name != tt.username || p.ViewOnly != tt.wantViewOnly {
+			t.Errorf("Verify(%q, %q) = %+v, want view-only %v", tt.username, tt.password, p, tt.wantViewOnly)
+		}
+	}
+}
+
+func TestLoadHtpasswdErrors(t *testing.T) {
+	hash := bcryptHash(t, "secret")
+	tests := []struct {
+		name, content string
+	}{
+		{"no hash", "alice\n"},
+		{"no username", ":" + hash + "\n"},
+		{"too many fields", "alice:" + hash + ":full:extra\n"},
+		{"unknown role", "alice:" + hash + ":admin\n"},
+		{"not bcrypt", "alice:$apr1$salt$hash\n"},
+		{"plain text", "alice:secret\n"},
+	}
+	for _, tt := range tests {
+		if _, err := LoadHtpasswd(writeHtpasswd(t, tt.content)); err == nil {
+			t.Errorf("%s: LoadHtpasswd() succeeded", tt.name)
+		}
+	}
+	if _, err := LoadHtpasswd(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("LoadHtpasswd() of a missing file succeeded")
+	}
+}
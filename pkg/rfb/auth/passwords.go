@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/subtle"
+	"errors"
+	"os"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Passwords holds the full access and view-only passwords of a security type. They can be
+// replaced with SetPassword while clients are negotiating.
+type Passwords struct {
+	// Password grants full access.
+	Password string
+	// ViewOnlyPassword, if set, is a second password granting view-only access.
+	ViewOnlyPassword string
+
+	mu sync.RWMutex
+}
+
+// SetPassword replaces both passwords. Clients that already authenticated are unaffected.
+func (p *Passwords) SetPassword(password, viewOnly string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Password = password
+	p.ViewOnlyPassword = viewOnly
+}
+
+func (p *Passwords) passwords() (password, viewOnly string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.Password, p.ViewOnlyPassword
+}
+
+// checkPassword compares a password against the full access and view-only passwords,
+// marking the session view-only when the latter matches.
+func checkPassword(password, full, viewOnly string, s *Session) error {
+	if full != "" && subtle.ConstantTimeCompare([]byte(password), []byte(full)) == 1 {
+		return nil
+	}
+	if viewOnly != "" && subtle.ConstantTimeCompare([]byte(password), []byte(viewOnly)) == 1 {
+		s.ViewOnly = true
+		return nil
+	}
+	return errors.New("Password is invalid")
+}
+
+// vncPasswdKey is the fixed key vncpasswd obfuscates password files with.
+var vncPasswdKey = []byte{23, 82, 107, 6, 35, 78, 88, 7}
+
+// ReadPasswordFile reads a password file. Files written by vncpasswd are recognised by
+// being 8 or 16 bytes that aren't a line of text, the second 8 bytes holding the view-only
+// password. Anything else is taken as a plain text password, less a trailing newline.
+func ReadPasswordFile(path string) (password, viewOnly string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	text := trimNewline(data)
+	if isVNCPasswdFile(data, text) {
+		return DecryptVNCPasswd(data)
+	}
+	if len(text) == 0 {
+		return "", "", errors.New(path + " is empty")
+	}
+	return string(text), "", nil
+}
+
+// isVNCPasswdFile reports whether data is a vncpasswd file rather than text, which is
+// checked without its trailing newline so that an 8 or 16 byte line of text isn't
+// mistaken for one.
+func isVNCPasswdFile(data, text []byte) bool {
+	if len(data) != 8 && len(data) != 16 {
+		return false
+	}
+	if !utf8.Valid(text) {
+		return true
+	}
+	for _, r := range string(text) {
+		if !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// trimNewline removes a trailing LF or CRLF.
+func trimNewline(data []byte) []byte {
+	if data, ok := bytes.CutSuffix(data, []byte("\n")); ok {
+		return bytes.TrimSuffix(data, []byte("\r"))
+	}
+	return data
+}
+
+// DecryptVNCPasswd decodes the contents of a vncpasswd file into the full access password
+// and, if present, the view-only one.
+func DecryptVNCPasswd(data []byte) (password, viewOnly string, err error) {
+	if len(data) != 8 && len(data) != 16 {
+		return "", "", errors.New("vncpasswd data must be 8 or 16 bytes")
+	}
+	block, err := newVNCCipher(vncPasswdKey)
+	if err != nil {
+		return "", "", err
+	}
+	plain := make([]byte, len(data))
+	for i := 0; i < len(data); i += block.BlockSize() {
+		block.Decrypt(plain[i:], data[i:])
+	}
+	password = cString(plain[:8])
+	if len(plain) == 16 {
+		viewOnly = cString(plain[8:])
+	}
+	return password, viewOnly, nil
+}
+
+// newVNCCipher returns a DES cipher for a VNC key. VNC's DES implementation reads key bits
+// in the opposite order to the standard one, so every key byte is reversed first.
+func newVNCCipher(key []byte) (cipher.Block, error) {
+	keyBytes := make([]byte, 8)
+	for i := 0; i < len(key) && i < 8; i++ {
+		keyBytes[i] = reverseBits(key[i])
+	}
+	return des.NewCipher(keyBytes)
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestReadPasswordFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		password string
+		viewOnly string
+		wantErr  bool
+	}{
+		{name: "text", data: []byte("secret\n"), password: "secret"},
+		{name: "text without newline", data: []byte("password"), password: "password"},
+		{name: "text with CRLF", data: []byte("secret\r\n"), password: "secret"},
+		{name: "8 bytes of text", data: []byte("1234567\n"), password: "1234567"},
+		{name: "16 bytes of text", data: []byte("123456789012345\n"), password: "123456789012345"},
+		{name: "16 bytes of text with CRLF", data: []byte("12345678901234\r\n"), password: "12345678901234"},
+		{name: "vncpasswd", data: mustHex(t, "dbd83cfd727a1458"), password: "password"},
+		{name: "vncpasswd with view-only", data: mustHex(t, "dbd83cfd727a14583b33820a27ba4dd0"),
+			password: "password", viewOnly: "viewonly"},
+		{name: "empty", data: nil, wantErr: true},
+		{name: "only a newline", data: []byte("\n"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "passwd")
+			if err := os.WriteFile(path, tt.data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			password, viewOnly, err := ReadPasswordFile(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ReadPasswordFile() = %q, %q, want an error", password, viewOnly)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if password != tt.password || viewOnly != tt.viewOnly {
+				t.Errorf("ReadPasswordFile() = %q, %q, want %q, %q", password, viewOnly, tt.password, tt.viewOnly)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned by TokenValidator.
+var (
+	ErrInvalidToken = errors.New("Token is invalid")
+	ErrExpiredToken = errors.New("Token has expired")
+	ErrReusedToken  = errors.New("Token has already been used")
+)
+
+// tokenLeeway is the clock skew tolerated when checking a token's validity period.
+const tokenLeeway = 30 * time.Second
+
+// TokenValidator checks single-use session tokens minted by a trusted party, such as a web
+// portal handing out links to websocket clients. Tokens are JWTs signed with HS256 and
+// must carry these claims:
+//
+//	exp        expiry time, in seconds since the epoch
+//	jti        a unique id, remembered until the token expires so it can only be used once
+//
+// They may also carry:
+//
+//	nbf        time before which the token is not valid
+//	sub        the principal the client is authenticated as
+//	view_only  true if the client may only watch the session
+type TokenValidator struct {
+	// Key is the HMAC key tokens are signed with.
+	Key []byte
+
+	mu   sync.Mutex
+	used map[string]time.Time // jti to expiry
+}
+
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	ID        string `json:"jti"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+	ViewOnly  bool   `json:"view_only"`
+}
+
+// Validate checks a token and returns the session it grants. A token can only be
+// validated successfully once.
+func (v *TokenValidator) Validate(token string) (*Session, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || len(v.Key) == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	mac := hmac.New(sha256.New, v.Key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeTokenPart(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+	var claims tokenClaims
+	if err := decodeTokenPart(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.ID == "" || claims.ExpiresAt == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	expiry := time.Unix(claims.ExpiresAt, 0)
+	if now.After(expiry.Add(tokenLeeway)) {
+		return nil, ErrExpiredToken
+	}
+	if claims.NotBefore != 0 && now.Add(tokenLeeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, ErrInvalidToken
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.used == nil {
+		v.used = make(map[string]time.Time)
+	}
+	for id, exp := range v.used {
+		if now.After(exp.Add(tokenLeeway)) {
+			delete(v.used, id)
+		}
+	}
+	if _, ok := v.used[claims.ID]; ok {
+		return nil, ErrReusedToken
+	}
+	v.used[claims.ID] = expiry
+
+	s := &Session{ViewOnly: claims.ViewOnly}
+	if claims.Subject != "" {
+		s.setPrincipal(&Principal{Name: claims.Subject, ViewOnly: claims.ViewOnly})
+	}
+	return s, nil
+}
+
+func decodeTokenPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+var testTokenKey = []byte("token signing key")
+
+// signToken returns a JWT of the given header and claims, signed with HS256.
+func signToken(t *testing.T, key []byte, header, claims any) string {
+	t.Helper()
+	encode := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+var hs256 = map[string]string{"alg": "HS256", "typ": "JWT"}
+
+func TestTokenValidator(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name         string
+		token        func(t *testing.T) string
+		wantErr      error
+		wantName     string
+		wantViewOnly bool
+		principal    bool
+	}{
+		{name: "valid", token: func(t *testing.T) string {
+			return signToken(t, testTokenKey, hs256, map[string]any{"jti": "1", "exp": now + 60, "sub": "alice"})
+		}, wantName: "alice", principal: true},
+		{name: "view-only", token: func(t *testing.T) string {
+			return signToken(t, testTokenKey, hs256, map[string]any{"jti": "2", "exp": now + 60, "sub": "bob", "view_only": true})
+		}, wantName: "bob", wantViewOnly: true, principal: true},
+		{name: "without subject", token: func(t *testing.T) string {
+			return signToken(t, testTokenKey, hs256, map[string]any{"jti": "3", "exp": now + 60})
+		}},
+		{name: "within leeway of expiry", token: func(t *testing.T) string {
+			return signToken(t, testTokenKey, hs256, map[string]any{"jti": "4", "exp": now - 10})
+		}},
+		{name: "expired", token: func(t *testing.T) string {
+			return signToken(t, testTokenKey, hs256, map[string]any{"jti": "5", "exp": now - 120})
+		}, wantErr: ErrExpiredToken},
+		{name: "not yet valid", token: func(t *testing.T) string {
+			return signToken(t, testTokenKey, hs256, map[string]any{"jti": "6", "exp": now + 600, "nbf": now + 300})
+		}, wantErr: ErrInvalidToken},
+		{name: "no id", token: func(t *testing.T) string {
+			return signToken(t, testTokenKey, hs256, map[string]any{"exp": now + 60})
+		}, wantErr: ErrInvalidToken},
+		{name: "no expiry", token: func(t *testing.T) string {
+			return signToken(t, testTokenKey, hs256, map[string]any{"jti": "7"})
+		}, wantErr: ErrInvalidToken},
+		{name: "wrong key", token: func(t *testing.T) string {
+			return signToken(t, []byte("another key"), hs256, map[string]any{"jti": "8", "exp": now + 60})
+		}, wantErr: ErrInvalidToken},
+		{name: "alg none", token: func(t *testing.T) string {
+			return signToken(t, testTokenKey, map[string]string{"alg": "none"}, map[string]any{"jti": "9", "exp": now + 60})
+		}, wantErr: ErrInvalidToken},
+		{name: "claims not JSON", token: func(t *testing.T) string {
+			header, _ := json.Marshal(hs256)
+			signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString([]byte("{"))
+			mac := hmac.New(sha256.New, testTokenKey)
+			mac.Write([]byte(signed))
+			return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+		}, wantErr: ErrInvalidToken},
+		{name: "two parts", token: func(t *testing.T) string { return "a.b" }, wantErr: ErrInvalidToken},
+		{name: "signature not base64", token: func(t *testing.T) string { return "a.b.!!" }, wantErr: ErrInvalidToken},
+		{name: "empty", token: func(t *testing.T) string { return "" }, wantErr: ErrInvalidToken},
+	}
+	v := &TokenValidator{Key: testTokenKey}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := v.Validate(tt.token(t))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.ViewOnly != tt.wantViewOnly {
+				t.Errorf("ViewOnly = %v, want %v", s.ViewOnly, tt.wantViewOnly)
+			}
+			if (s.Principal != nil) != tt.principal || s.Principal != nil && s.Principal.Name != tt.wantName {
+				t.Errorf("Principal = %+v, want %q", s.Principal, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestTokenValidatorSingleUse(t *testing.T) {
+	v := &TokenValidator{Key: testTokenKey}
+	token := signToken(t, testTokenKey, hs256, map[string]any{"jti": "once", "exp": time.Now().Unix() + 60})
+	if _, err := v.Validate(token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Validate(token); !errors.Is(err, ErrReusedToken) {
+		t.Errorf("second Validate() error = %v, want ErrReusedToken", err)
+	}
+}
+
+func TestTokenValidatorWithoutKey(t *testing.T) {
+	v := &TokenValidator{}
+	token := signToken(t, nil, hs256, map[string]any{"jti": "1", "exp": time.Now().Unix() + 60})
+	if _, err := v.Validate(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Validate() error = %v, want ErrInvalidToken", err)
+	}
+}
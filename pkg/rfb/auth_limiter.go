@@ -0,0 +1,133 @@
+package rfb
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
+)
+
+// Defaults for the authentication failure limits on ServerOpts.
+const (
+	DefaultAuthFailureThreshold = 5
+	DefaultAuthBlacklistTimeout = 10 * time.Second
+	DefaultAuthFailureDelay     = 500 * time.Millisecond
+)
+
+const (
+	// maxAuthFailureDelay caps the backoff applied before answering a failed attempt.
+	maxAuthFailureDelay = 30 * time.Second
+	// maxAuthBlacklistTimeout caps how long repeat offenders are blacklisted for.
+	maxAuthBlacklistTimeout = 24 * time.Hour
+	// authFailureMemory is how long an address's failures are remembered without new ones.
+	authFailureMemory = time.Hour
+)
+
+// authLimiter tracks failed authentications per source address. Each failure delays the
+// response with exponential backoff, and an address reaching the threshold is blacklisted.
+// The blacklist period doubles every time the same address is blacklisted again.
+type authLimiter struct {
+	threshold int
+	timeout   time.Duration
+	delay     time.Duration
+	now       func() time.Time
+
+	mu      sync.Mutex
+	sources map[string]*authFailures
+}
+
+type authFailures struct {
+	count        int           // failures since the last blacklisting
+	timeout      time.Duration // next blacklist period
+	blockedUntil time.Time
+	last         time.Time
+}
+
+func newAuthLimiter(threshold int, timeout, delay time.Duration) *authLimiter {
+	if threshold == 0 {
+		threshold = DefaultAuthFailureThreshold
+	}
+	if timeout <= 0 {
+		timeout = DefaultAuthBlacklistTimeout
+	}
+	if delay == 0 {
+		delay = DefaultAuthFailureDelay
+	}
+	return &authLimiter{
+		threshold: threshold,
+		timeout:   timeout,
+		delay:     delay,
+		now:       time.Now,
+		sources:   make(map[string]*authFailures),
+	}
+}
+
+// blocked returns how much longer the address is blacklisted for, or zero.
+func (l *authLimiter) blocked(addr string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.sources[addr]
+	if !ok {
+		return 0
+	}
+	if wait := f.blockedUntil.Sub(l.now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// failure records a failed attempt and returns how long to wait before answering it.
+func (l *authLimiter) failure(addr string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.prune(now)
+
+	f, ok := l.sources[addr]
+	if !ok {
+		f = &authFailures{timeout: l.timeout}
+		l.sources[addr] = f
+	}
+	f.count++
+	f.last = now
+
+	var delay time.Duration
+	if l.delay > 0 {
+		delay = maxAuthFailureDelay
+		if f.count < 16 {
+			delay = min(l.delay<<(f.count-1), maxAuthFailureDelay)
+		}
+	}
+
+	if l.threshold > 0 && f.count >= l.threshold {
+		log.Warningf("Too many security failures from %s, blacklisting for %s", addr, f.timeout)
+		f.blockedUntil = now.Add(f.timeout)
+		f.timeout = min(f.timeout*2, maxAuthBlacklistTimeout)
+		f.count = 0
+	}
+	return delay
+}
+
+// success forgets the failures recorded for the address.
+func (l *authLimiter) success(addr string) {
+	l.mu.Lock()
+	delete(l.sources, addr)
+	l.mu.Unlock()
+}
+
+func (l *authLimiter) prune(now time.Time) {
+	for addr, f := range l.sources {
+		if now.After(f.blockedUntil) && now.Sub(f.last) > authFailureMemory {
+			delete(l.sources, addr)
+		}
+	}
+}
+
+// hostOf strips the port from an address, if it has one.
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,140 @@
+package rfb
+
+import (
+	"testing"
+	"time"
+)
+
+// testLimiter returns a limiter whose clock only moves when the returned function is called.
+func testLimiter(threshold int, timeout, delay time.Duration) (*authLimiter, func(time.Duration)) {
+	l := newAuthLimiter(threshold, timeout, delay)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestAuthLimiterThreshold(t *testing.T) {
+	l, advance := testLimiter(3, time.Minute, -1)
+	tests := []struct {
+		name        string
+		advance     time.Duration
+		failures    int
+		wantBlocked time.Duration
+	}{
+		{name: "under the threshold", failures: 2},
+		{name: "reaching the threshold", failures: 1, wantBlocked: time.Minute},
+		{name: "part way through", advance: 20 * time.Second, wantBlocked: 40 * time.Second},
+		{name: "after the timeout", advance: 40 * time.Second},
+		{name: "counting again", failures: 2},
+		{name: "blacklisted again for twice as long", failures: 1, wantBlocked: 2 * time.Minute},
+		{name: "after the longer timeout", advance: 2 * time.Minute},
+		{name: "and a third time", failures: 3, wantBlocked: 4 * time.Minute},
+	}
+	for _, tt := range tests {
+		advance(tt.advance)
+		for range tt.failures {
+			l.failure("10.0.0.1")
+		}
+		if got := l.blocked("10.0.0.1"); got != tt.wantBlocked {
+			t.Errorf("%s: blocked() = %v, want %v", tt.name, got, tt.wantBlocked)
+		}
+	}
+	if got := l.blocked("10.0.0.2"); got != 0 {
+		t.Errorf("blocked() of another address = %v, want 0", got)
+	}
+}
+
+func TestAuthLimiterBlacklistCap(t *testing.T) {
+	l, advance := testLimiter(1, 10*time.Hour, -1)
+	for _, want := range []time.Duration{10 * time.Hour, 20 * time.Hour, maxAuthBlacklistTimeout, maxAuthBlacklistTimeout} {
+		l.failure("10.0.0.1")
+		if got := l.blocked("10.0.0.1"); got != want {
+			t.Errorf("blocked() = %v, want %v", got, want)
+		}
+		advance(want)
+	}
+}
+
+func TestAuthLimiterBackoff(t *testing.T) {
+	tests := []struct {
+		name  string
+		delay time.Duration
+		want  []time.Duration
+	}{
+		{name: "doubling", delay: 100 * time.Millisecond, want: []time.Duration{
+			100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, 1600 * time.Millisecond,
+		}},
+		{name: "capped", delay: 10 * time.Second, want: []time.Duration{
+			10 * time.Second, 20 * time.Second, maxAuthFailureDelay, maxAuthFailureDelay,
+		}},
+		{name: "disabled", delay: -1, want: []time.Duration{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		l, _ := testLimiter(-1, 0, tt.delay)
+		for i, want := range tt.want {
+			if got := l.failure("10.0.0.1"); got != want {
+				t.Errorf("%s: failure %d delay = %v, want %v", tt.name, i+1, got, want)
+			}
+		}
+	}
+
+	// The shift can't overflow however many failures there are.
+	l, _ := testLimiter(-1, 0, time.Second)
+	for i := range 100 {
+		if got := l.failure("10.0.0.1"); got <= 0 || got > maxAuthFailureDelay {
+			t.Fatalf("failure %d delay = %v", i+1, got)
+		}
+	}
+}
+
+func TestAuthLimiterSuccess(t *testing.T) {
+	l, _ := testLimiter(3, time.Minute, 100*time.Millisecond)
+	l.failure("10.0.0.1")
+	l.failure("10.0.0.1")
+	l.success("10.0.0.1")
+	if got := l.failure("10.0.0.1"); got != 100*time.Millisecond {
+		t.Errorf("delay after a success = %v, want the initial delay", got)
+	}
+	if got := l.blocked("10.0.0.1"); got != 0 {
+		t.Errorf("blocked() = %v, want the count restarted by the success", got)
+	}
+}
+
+func TestAuthLimiterPrune(t *testing.T) {
+	l, advance := testLimiter(2, 2*time.Hour, -1)
+	l.failure("10.0.0.1")
+	l.failure("10.0.0.2")
+	l.failure("10.0.0.2")
+	advance(authFailureMemory + time.Second)
+	l.failure("10.0.0.3")
+	if _, ok := l.sources["10.0.0.1"]; ok {
+		t.Error("failures older than authFailureMemory were kept")
+	}
+	if _, ok := l.sources["10.0.0.2"]; !ok {
+		t.Error("a blacklisted address was forgotten before its timeout")
+	}
+
+	advance(time.Hour)
+	l.failure("10.0.0.3")
+	if _, ok := l.sources["10.0.0.2"]; ok {
+		t.Error("a blacklisted address was kept long after its timeout")
+	}
+	if _, ok := l.sources["10.0.0.3"]; !ok {
+		t.Error("recent failures were forgotten")
+	}
+}
+
+func TestHostOf(t *testing.T) {
+	tests := []struct{ addr, want string }{
+		{"10.0.0.1:5900", "10.0.0.1"},
+		{"10.0.0.1", "10.0.0.1"},
+		{"[2001:db8::1]:5900", "2001:db8::1"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := hostOf(tt.addr); got != tt.want {
+			t.Errorf("hostOf(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -8,7 +8,10 @@ import (
 
 	"github.com/kamrankamilli/gsvnc/pkg/buffer"
 	"github.com/kamrankamilli/gsvnc/pkg/display"
+	"github.com/kamrankamilli/gsvnc/pkg/display/providers"
 	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/auth"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/encodings"
 	"github.com/kamrankamilli/gsvnc/pkg/rfb/events"
 )
 
@@ -18,22 +21,59 @@ type Conn struct {
 	s       *Server
 	buf     *buffer.ReadWriter
 	display *display.Display
+	// encodings are this connection's instances of the enabled encodings.
+	encodings []encodings.Encoding
+
+	// remoteAddr is the client's address, taken from the HTTP request for websockets.
+	remoteAddr  string
+	connectedAt time.Time
+	// session is set once security negotiation succeeds. It is guarded by the
+	// server's connMu.
+	session *auth.Session
+	// preauth is set when the client was authenticated before the RFB handshake, such as
+	// by a websocket token. Security negotiation then only offers None.
+	preauth *auth.Session
+}
+
+// SessionInfo describes a connected client.
+type SessionInfo struct {
+	RemoteAddr  string
+	Principal   string
+	ViewOnly    bool
+	ConnectedAt time.Time
 }
 
-func (s *Server) newConn(c net.Conn) *Conn {
+func (s *Server) newConn(c net.Conn, remoteAddr string) *Conn {
 	buf := buffer.NewReadWriteBuffer(c)
+	// Stateful encodings need their own instances for every connection.
+	encs := encodings.ForConnection(s.enabledEncodings)
 	conn := &Conn{
-		c:   c,
-		s:   s,
-		buf: buf,
-		display: display.NewDisplay(&display.Opts{
-			Width:           s.width,
-			Height:          s.height,
-			Buffer:          buf,
-			DisplayProvider: s.displayProvider,
-			GetEncodingFunc: s.GetEncoding,
-		}),
+		c:           c,
+		s:           s,
+		buf:         buf,
+		encodings:   encs,
+		remoteAddr:  remoteAddr,
+		connectedAt: time.Now(),
 	}
+	s.connMu.RLock()
+	width, height, screenID := s.width, s.height, s.screenID
+	s.connMu.RUnlock()
+	conn.display = display.NewDisplay(&display.Opts{
+		Width:           width,
+		Height:          height,
+		ScreenID:        screenID,
+		Buffer:          buf,
+		DisplayProvider: s.displayProvider,
+		GetEncodingFunc: func(requested []int32) encodings.Encoding {
+			return chooseEncoding(encs, requested)
+		},
+		CopyRect: encodingEnabled(encs, (&encodings.CopyRectEncoding{}).Code()),
+		ResizeDesktop: func(width, height int, screenID uint32) error {
+			return s.resizeDesktop(conn, width, height, screenID)
+		},
+		ClipboardLimits: s.clipboardLimits,
+		Clipboard:       s.clipboard,
+	})
 
 	s.connMu.Lock()
 	if s.connections == nil { // extra safety
@@ -45,12 +85,34 @@ func (s *Server) newConn(c net.Conn) *Conn {
 	return conn
 }
 
+// Principal returns who the client authenticated as, or nil if the security type used
+// has no notion of users.
+func (c *Conn) Principal() *auth.Principal {
+	c.s.connMu.RLock()
+	defer c.s.connMu.RUnlock()
+	if c.session == nil {
+		return nil
+	}
+	return c.session.Principal
+}
+
+// IsViewOnly returns true if the client may watch the session but not send input.
+func (c *Conn) IsViewOnly() bool {
+	c.s.connMu.RLock()
+	defer c.s.connMu.RUnlock()
+	return c.session != nil && c.session.ViewOnly
+}
+
+func (c *Conn) setSession(session *auth.Session) {
+	c.s.connMu.Lock()
+	c.session = session
+	c.s.connMu.Unlock()
+	c.display.SetViewOnly(session.ViewOnly)
+}
+
 func (c *Conn) serve() {
 	defer func() {
-		c.c.Close()
-		c.buf.Close()
-		c.s.removeConn(c)
-		c.display.Close() // keep only this one
+		c.close()
 		runtime.GC()
 		debug.FreeOSMemory()
 	}()
@@ -85,12 +147,73 @@ func (c *Conn) serve() {
 	}
 }
 
+// closeFlushTimeout bounds how long close waits for queued messages to reach the client.
+const closeFlushTimeout = 2 * time.Second
+
+// close flushes anything queued for the client, then tears the connection down.
+func (c *Conn) close() {
+	c.buf.Close()
+	select {
+	case <-c.buf.Done():
+	case <-time.After(closeFlushTimeout):
+	}
+	c.c.Close()
+	c.s.removeConn(c)
+	c.display.Close() // keep only this one
+	encodings.CloseConnection(c.encodings)
+}
+
+// resizeDesktop resizes the host's desktop at the request of from, then tells every client
+// the new size and the screen ID it asked for.
+func (s *Server) resizeDesktop(from *Conn, width, height int, screenID uint32) error {
+	s.resizeMu.Lock()
+	defer s.resizeMu.Unlock()
+	if err := providers.ResizeScreen(width, height); err != nil {
+		return err
+	}
+	s.connMu.Lock()
+	s.width, s.height, s.screenID = width, height, screenID
+	connections := make([]*Conn, 0, len(s.connections))
+	for conn := range s.connections {
+		connections = append(connections, conn)
+	}
+	s.connMu.Unlock()
+
+	log.Infof("Desktop resized to %dx%d", width, height)
+	for _, conn := range connections {
+		conn.display.DesktopResized(width, height, screenID, conn == from)
+	}
+	return nil
+}
+
 func (s *Server) removeConn(conn *Conn) {
 	s.connMu.Lock()
 	delete(s.connections, conn)
 	s.connMu.Unlock()
 }
 
+// Sessions returns the clients that have completed the handshake.
+func (s *Server) Sessions() []SessionInfo {
+	s.connMu.RLock()
+	defer s.connMu.RUnlock()
+	out := make([]SessionInfo, 0, len(s.connections))
+	for conn := range s.connections {
+		if conn.session == nil {
+			continue
+		}
+		info := SessionInfo{
+			RemoteAddr:  conn.remoteAddr,
+			ViewOnly:    conn.session.ViewOnly,
+			ConnectedAt: conn.connectedAt,
+		}
+		if conn.session.Principal != nil {
+			info.Principal = conn.session.Principal.Name
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
 func (s *Server) CloseAllConnections() {
 	s.connMu.RLock()
 	connections := make([]*Conn, 0, len(s.connections))
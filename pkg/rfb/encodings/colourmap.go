@@ -0,0 +1,21 @@
+package encodings
+
+// colourCubeLevels is the number of levels of red, green and blue in the colour map sent to
+// clients that ask for a colour-mapped pixel format. Pixel values index a 6x6x6 cube, so any
+// format with a depth of at least 8 can use it.
+const colourCubeLevels = 6
+
+// ColourMap returns the entries of the colour map used for colour-mapped pixel formats, as
+// 16-bit red, green and blue.
+func ColourMap() [][3]uint16 {
+	const max = colourCubeLevels - 1
+	out := make([][3]uint16, 0, colourCubeLevels*colourCubeLevels*colourCubeLevels)
+	for r := range colourCubeLevels {
+		for g := range colourCubeLevels {
+			for b := range colourCubeLevels {
+				out = append(out, [3]uint16{uint16(r * 0xffff / max), uint16(g * 0xffff / max), uint16(b * 0xffff / max)})
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,24 @@
+package encodings
+
+import (
+	"image"
+	"io"
+
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// CopyRectEncoding implements CopyRect, which has the client copy an area it already has from
+// elsewhere on its screen. Rather than being chosen for updates, it is used for the areas that
+// moved alongside the encoding that was.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#copyrect-encoding
+type CopyRectEncoding struct{}
+
+// Code returns the code for CopyRect.
+func (c *CopyRectEncoding) Code() int32 { return 1 }
+
+// Supplementary marks CopyRect as only being used alongside another encoding.
+func (c *CopyRectEncoding) Supplementary() {}
+
+// HandleBuffer writes nothing. CopyRect rectangles carry the position they are copied from
+// rather than pixels, and are written by the display.
+func (c *CopyRectEncoding) HandleBuffer(w io.Writer, f *types.PixelFormat, img *image.RGBA) {}
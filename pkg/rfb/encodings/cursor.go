@@ -0,0 +1,77 @@
+package encodings
+
+import (
+	"image"
+	"io"
+
+	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// cursorOpaque is the alpha from which a cursor pixel is drawn rather than left transparent,
+// since the client only has a 1-bit mask.
+const cursorOpaque = 0x80
+
+// WriteCursor writes the data of a Cursor pseudo-encoding rectangle for img: its pixels in
+// the client's format, then a bitmask of the ones to draw.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#cursor-pseudo-encoding
+func WriteCursor(w io.Writer, f *types.PixelFormat, img *image.NRGBA) {
+	// The converter ignores alpha, so the colours can be taken as they are.
+	pixels := &image.RGBA{Pix: img.Pix, Stride: img.Stride, Rect: img.Rect}
+	_, _ = w.Write(converterFor(f).appendPixels(nil, pixels))
+	_, _ = w.Write(cursorMask(img, func(c []uint8) bool { return c[3] >= cursorOpaque }))
+}
+
+// WriteXCursor writes the data of an XCursor pseudo-encoding rectangle for img. XCursor has
+// only two colours, so the dark and light pixels are each drawn in their average colour.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#x-cursor-pseudo-encoding
+func WriteXCursor(w io.Writer, img *image.NRGBA) {
+	b := img.Bounds()
+	if b.Empty() {
+		// An empty cursor has no colours either.
+		return
+	}
+	dark := func(c []uint8) bool { return 299*int(c[0])+587*int(c[1])+114*int(c[2]) < 128*1000 }
+	var sums [2][4]int // dark, light: red, green, blue, count
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):][:b.Dx()*4]
+		for i := 0; i < len(row); i += 4 {
+			c := row[i : i+4]
+			if c[3] < cursorOpaque {
+				continue
+			}
+			s := &sums[1]
+			if dark(c) {
+				s = &sums[0]
+			}
+			s[0], s[1], s[2], s[3] = s[0]+int(c[0]), s[1]+int(c[1]), s[2]+int(c[2]), s[3]+1
+		}
+	}
+	for i, fallback := range []uint8{0, 0xff} {
+		s := sums[i]
+		if s[3] == 0 {
+			util.Write(w, [3]uint8{fallback, fallback, fallback})
+			continue
+		}
+		util.Write(w, [3]uint8{uint8(s[0] / s[3]), uint8(s[1] / s[3]), uint8(s[2] / s[3])})
+	}
+	_, _ = w.Write(cursorMask(img, dark))
+	_, _ = w.Write(cursorMask(img, func(c []uint8) bool { return c[3] >= cursorOpaque }))
+}
+
+// cursorMask returns a bitmap of img with the bits set for the pixels set returns true for.
+// Each row starts on a new byte, most significant bit first.
+func cursorMask(img *image.NRGBA, set func(c []uint8) bool) []byte {
+	b := img.Bounds()
+	rowBytes := (b.Dx() + 7) / 8
+	out := make([]byte, rowBytes*b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):][:b.Dx()*4]
+		for x := 0; x < b.Dx(); x++ {
+			if set(row[x*4 : x*4+4]) {
+				out[y*rowBytes+x/8] |= 0x80 >> (x % 8)
+			}
+		}
+	}
+	return out
+}
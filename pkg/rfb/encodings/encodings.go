@@ -4,6 +4,7 @@ import (
 	"image"
 	"io"
 
+	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
 	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
 )
 
@@ -13,9 +14,44 @@ type Encoding interface {
 	HandleBuffer(w io.Writer, format *types.PixelFormat, img *image.RGBA)
 }
 
+// Stateful is implemented by encodings that keep state across rectangles for the lifetime
+// of a connection, such as a compression stream. NewConnection returns a fresh instance.
+type Stateful interface {
+	Encoding
+	NewConnection() Encoding
+}
+
+// Limited is implemented by encodings that restrict the size of a rectangle. Larger updates
+// are split into several rectangles before they are encoded. Zero means no limit.
+type Limited interface {
+	Encoding
+	RectLimits() (maxWidth, maxHeight, maxArea int)
+}
+
+// WholeFrame is implemented by encodings, such as video codecs, that are always sent the
+// whole framebuffer as one rectangle rather than just the areas that changed.
+type WholeFrame interface {
+	Encoding
+	WholeFrame()
+}
+
+// Supplementary is implemented by encodings that are never chosen for an update, but may be
+// used for some of its rectangles alongside the encoding that was.
+type Supplementary interface {
+	Encoding
+	Supplementary()
+}
+
 // DefaultEncodings lists the encodings enabled by default on the server.
 var DefaultEncodings = []Encoding{
-	NewTight(TightOptions{JPEGQuality: 75}),
+	&CopyRectEncoding{},
+	NewTight(TightOptions{}),
+	&ZRLEEncoding{},
+	&ZlibHexEncoding{},
+	&ZlibEncoding{},
+	&HextileEncoding{},
+	&CoRREEncoding{},
+	&RREEncoding{},
 	&RawEncoding{}, // fallback if client doesn't speak Tight
 }
 
@@ -27,3 +63,54 @@ func GetDefaults() []Encoding {
 	}
 	return out
 }
+
+// ForConnection returns the encodings to use on a new connection, replacing stateful ones
+// with fresh instances.
+func ForConnection(encs []Encoding) []Encoding {
+	out := make([]Encoding, len(encs))
+	for i, enc := range encs {
+		if stateful, ok := enc.(Stateful); ok {
+			enc = stateful.NewConnection()
+		}
+		out[i] = enc
+	}
+	return out
+}
+
+// CloseConnection releases anything held by the encodings of a connection that has ended,
+// such as a hardware or external encoder.
+func CloseConnection(encs []Encoding) {
+	for _, enc := range encs {
+		if closer, ok := enc.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Error("Could not close encoding: ", err)
+			}
+		}
+	}
+}
+
+// SplitRect splits r into rectangles the given encoding can send.
+func SplitRect(enc Encoding, r image.Rectangle) []image.Rectangle {
+	limited, ok := enc.(Limited)
+	if !ok {
+		return []image.Rectangle{r}
+	}
+	maxWidth, maxHeight, maxArea := limited.RectLimits()
+	w, h := r.Dx(), r.Dy()
+	if maxWidth > 0 {
+		w = min(w, maxWidth)
+	}
+	if maxHeight > 0 {
+		h = min(h, maxHeight)
+	}
+	if maxArea > 0 {
+		h = max(1, min(h, maxArea/max(w, 1)))
+	}
+	out := make([]image.Rectangle, 0, ((r.Dx()+w-1)/w)*((r.Dy()+h-1)/h))
+	for y := r.Min.Y; y < r.Max.Y; y += h {
+		for x := r.Min.X; x < r.Max.X; x += w {
+			out = append(out, image.Rect(x, y, x+w, y+h).Intersect(r))
+		}
+	}
+	return out
+}
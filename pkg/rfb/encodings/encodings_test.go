@@ -0,0 +1,223 @@
+package encodings
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// testFormats are pixel formats clients ask for, covering each pixel size and byte order,
+// the three byte pixels of ZRLE and Tight, and a colour map.
+var testFormats = []struct {
+	name string
+	f    types.PixelFormat
+}{
+	{"8bpp bgr233", types.PixelFormat{BPP: 8, Depth: 8, TrueColour: 1, RedMax: 7, GreenMax: 7, BlueMax: 3, GreenShift: 3, BlueShift: 6}},
+	{"8bpp colour map", types.PixelFormat{BPP: 8, Depth: 8}},
+	{"16bpp rgb565 little endian", types.PixelFormat{BPP: 16, Depth: 16, TrueColour: 1, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}},
+	{"16bpp rgb565 big endian", types.PixelFormat{BPP: 16, Depth: 16, BigEndian: 1, TrueColour: 1, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}},
+	{"32bpp rgb888 little endian", types.PixelFormat{BPP: 32, Depth: 24, TrueColour: 1, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8}},
+	{"32bpp rgb888 big endian", types.PixelFormat{BPP: 32, Depth: 24, BigEndian: 1, TrueColour: 1, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8}},
+	{"32bpp bgr888 in the high bytes", types.PixelFormat{BPP: 32, Depth: 24, BigEndian: 1, TrueColour: 1, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 8, GreenShift: 16, BlueShift: 24}},
+	{"32bpp rgb101010", types.PixelFormat{BPP: 32, Depth: 30, TrueColour: 1, RedMax: 1023, GreenMax: 1023, BlueMax: 1023, RedShift: 20, GreenShift: 10}},
+}
+
+// testSizes include sizes that are not whole tiles of Hextile or ZRLE, and a single pixel.
+var testSizes = [][2]int{{1, 1}, {16, 16}, {37, 21}, {64, 64}, {70, 65}, {130, 3}}
+
+// testPatterns are the kinds of content encoders treat differently.
+var testPatterns = []struct {
+	name   string
+	colour func(x, y int) color.RGBA
+}{
+	{"solid", func(x, y int) color.RGBA { return color.RGBA{40, 90, 200, 255} }},
+	{"two colours", func(x, y int) color.RGBA {
+		if (x/3+y)%5 == 0 {
+			return color.RGBA{255, 255, 255, 255}
+		}
+		return color.RGBA{20, 20, 20, 255}
+	}},
+	{"blocks", func(x, y int) color.RGBA {
+		colours := []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 0, 255}, {0, 0, 0, 255}, {128, 128, 128, 255}}
+		return colours[(x/7+y/5)%len(colours)]
+	}},
+	{"runs", func(x, y int) color.RGBA { return color.RGBA{uint8(x / 8 * 32), uint8(y * 4), 60, 255} }},
+	{"smooth", func(x, y int) color.RGBA { return color.RGBA{uint8(x * y), uint8(x*y/2 + y), uint8(x*y/3 + x), 255} }},
+	{"noise", func(x, y int) color.RGBA {
+		h := uint32(x)*2654435761 ^ uint32(y)*40503
+		h ^= h >> 15
+		h *= 2246822519
+		h ^= h >> 13
+		return color.RGBA{uint8(h), uint8(h >> 8), uint8(h >> 16), 255}
+	}},
+}
+
+// testPattern returns the test pattern with the given name.
+func testPattern(name string) func(x, y int) color.RGBA {
+	for _, p := range testPatterns {
+		if p.name == name {
+			return p.colour
+		}
+	}
+	panic("no test pattern " + name)
+}
+
+// testImage draws a pattern in an image of the given size. It is part of a larger image, so
+// its bounds don't start at zero.
+func testImage(width, height int, colour func(x, y int) color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width+5, height+7))
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			img.SetRGBA(x, y, colour(x, y))
+		}
+	}
+	return img.SubImage(image.Rect(3, 5, 3+width, 5+height)).(*image.RGBA)
+}
+
+// rectDecoder decodes one rectangle to its pixel values. It keeps any state, such as zlib
+// streams, from one rectangle to the next.
+type rectDecoder func(r *bytes.Reader, f *types.PixelFormat, width, height int) ([]uint32, error)
+
+// checkRoundTrip encodes every test pattern at every test size in each test format, and
+// checks that decoding gives back the pixel values the image converts to. Each format has
+// one encoder and decoder, so streams carry on across rectangles as they do on a connection.
+func checkRoundTrip(t *testing.T, newEncoder func() Encoding, newDecoder func() rectDecoder) {
+	t.Helper()
+	for _, tf := range testFormats {
+		t.Run(tf.name, func(t *testing.T) {
+			enc, decode := newEncoder(), newDecoder()
+			f := tf.f
+			for _, size := range testSizes {
+				for _, p := range testPatterns {
+					img := testImage(size[0], size[1], p.colour)
+					var buf bytes.Buffer
+					enc.HandleBuffer(&buf, &f, img)
+
+					r := bytes.NewReader(buf.Bytes())
+					got, err := decode(r, &f, size[0], size[1])
+					if err != nil {
+						t.Fatalf("%s %dx%d: %v", p.name, size[0], size[1], err)
+					}
+					if r.Len() != 0 {
+						t.Fatalf("%s %dx%d: %d bytes left over", p.name, size[0], size[1], r.Len())
+					}
+					want := pixelValues(&f, img)
+					if i := firstDifference(got, want); i >= 0 {
+						t.Fatalf("%s %dx%d: pixel (%d, %d) = %#x, want %#x",
+							p.name, size[0], size[1], i%size[0], i/size[0], got[i], want[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+// firstDifference returns the index of the first value that differs, or -1.
+func firstDifference(got, want []uint32) int {
+	for i := range want {
+		if i >= len(got) || got[i] != want[i] {
+			return i
+		}
+	}
+	if len(got) > len(want) {
+		return len(want)
+	}
+	return -1
+}
+
+// decodeTiles calls decodeTile for each tile of a rectangle in turn, the last in each row
+// and column cut short by the edge of the rectangle.
+func decodeTiles(width, height, tileSize int, decodeTile func(pixels []uint32, tile image.Rectangle) error) ([]uint32, error) {
+	pixels := make([]uint32, width*height)
+	bounds := image.Rect(0, 0, width, height)
+	for ty := 0; ty < height; ty += tileSize {
+		for tx := 0; tx < width; tx += tileSize {
+			tile := image.Rect(tx, ty, tx+tileSize, ty+tileSize).Intersect(bounds)
+			if err := decodeTile(pixels, tile); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return pixels, nil
+}
+
+// readPixel reads a pixel value of the given size in the client's byte order. Three byte
+// pixels are the three bytes of a 32-bit value that hold the colours.
+func readPixel(r io.Reader, f *types.PixelFormat, size int) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:size]); err != nil {
+		return 0, err
+	}
+	var v uint32
+	for i := 0; i < size; i++ {
+		if f.BigEndian != 0 {
+			v = v<<8 | uint32(b[i])
+		} else {
+			v |= uint32(b[i]) << (8 * i)
+		}
+	}
+	if size == 3 && colourMask(f)&0xff000000 != 0 {
+		v <<= 8
+	}
+	return v, nil
+}
+
+func readByte(r io.Reader) (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r, b[:])
+	return b[0], err
+}
+
+// readChunk reads data preceded by its length as a big endian uint32 or uint16.
+func readChunk[T uint16 | uint32](r io.Reader) ([]byte, error) {
+	var n T
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	data := make([]byte, n)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+// fillRect sets the pixels of a rectangle within an image width pixels wide, checking it
+// lies within the image.
+func fillRect(pixels []uint32, width int, r image.Rectangle, v uint32) error {
+	height := len(pixels) / width
+	if !r.In(image.Rect(0, 0, width, height)) || r.Empty() {
+		return fmt.Errorf("rectangle %v outside %dx%d", r, width, height)
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			pixels[y*width+x] = v
+		}
+	}
+	return nil
+}
+
+// zlibReader decompresses a zlib stream that arrives in pieces, one for each rectangle or
+// tile, as the encoders' streams last for the whole connection.
+type zlibReader struct {
+	in bytes.Buffer
+	zr io.ReadCloser
+}
+
+// feed adds compressed data and returns the reader of the decompressed stream.
+func (z *zlibReader) feed(compressed []byte) (io.Reader, error) {
+	z.in.Write(compressed)
+	if z.zr == nil {
+		zr, err := zlib.NewReader(&z.in)
+		if err != nil {
+			return nil, err
+		}
+		z.zr = zr
+	}
+	return z.zr, nil
+}
@@ -0,0 +1,140 @@
+package encodings
+
+import (
+	"image"
+	"io"
+	"sync"
+
+	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
+	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// H.264 rectangle flags.
+const (
+	h264ResetContext     = 1
+	h264ResetAllContexts = 2
+)
+
+// H264Encoder compresses frames of a fixed size into an H.264 stream.
+type H264Encoder interface {
+	// Encode returns the access unit for a frame in Annex B byte-stream format. A keyframe
+	// is produced when keyframe is true.
+	Encode(img *image.RGBA, keyframe bool) ([]byte, error)
+	Close() error
+}
+
+// Keyframer is implemented by encodings that can be asked to make their next rectangle
+// decodable on its own, such as when a client requests a full update.
+type Keyframer interface {
+	Encoding
+	ForceKeyframe()
+}
+
+// H264Encoding implements Open H.264. A single decoder context is kept on the client, and
+// whenever the rectangle moves or changes size the encoder is restarted and the client is
+// told to reset its contexts.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#open-h-264-encoding
+type H264Encoding struct {
+	// NewEncoder starts an encoder for frames of the given size. Both are always even.
+	NewEncoder func(width, height int) (H264Encoder, error)
+
+	// mu guards everything below, and stops the encoder being closed while a frame is in it.
+	mu       sync.Mutex
+	enc      H264Encoder
+	rect     image.Rectangle
+	frame    *image.RGBA
+	keyframe bool
+}
+
+// Code returns the code for H.264.
+func (h *H264Encoding) Code() int32 { return 50 }
+
+// NewConnection returns an encoding that starts its own encoder on first use.
+func (h *H264Encoding) NewConnection() Encoding { return &H264Encoding{NewEncoder: h.NewEncoder} }
+
+// WholeFrame marks H.264 as sending the whole framebuffer, so the encoder is not restarted
+// every time a different area changes.
+func (h *H264Encoding) WholeFrame() {}
+
+// ForceKeyframe makes the next rectangle a keyframe.
+func (h *H264Encoding) ForceKeyframe() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.keyframe = true
+}
+
+// Close stops the encoder.
+func (h *H264Encoding) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.closeEncoder()
+}
+
+func (h *H264Encoding) closeEncoder() error {
+	if h.enc == nil {
+		return nil
+	}
+	err := h.enc.Close()
+	h.enc = nil
+	return err
+}
+
+// HandleBuffer handles an image sample.
+func (h *H264Encoding) HandleBuffer(w io.Writer, f *types.PixelFormat, img *image.RGBA) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var flags uint32
+	if b := img.Bounds(); h.enc == nil || b != h.rect {
+		if err := h.restart(b); err != nil {
+			log.Error("Could not start H.264 encoder: ", err)
+			// An empty rectangle leaves the client's picture as it was.
+			util.Write(w, uint32(0))
+			util.Write(w, uint32(h264ResetAllContexts))
+			return
+		}
+		flags = h264ResetAllContexts
+	} else if h.keyframe {
+		flags = h264ResetContext
+	}
+
+	h.copyFrame(img)
+	data, err := h.enc.Encode(h.frame, flags != 0)
+	if err != nil {
+		log.Error("Could not encode H.264 frame: ", err)
+		_ = h.closeEncoder()
+		data = nil
+	}
+	h.keyframe = false
+	util.Write(w, uint32(len(data)))
+	util.Write(w, flags)
+	_, _ = w.Write(data)
+}
+
+// restart replaces the encoder with one for rectangles the size of r.
+func (h *H264Encoding) restart(r image.Rectangle) error {
+	_ = h.closeEncoder()
+	width, height := (r.Dx()+1)&^1, (r.Dy()+1)&^1
+	enc, err := h.NewEncoder(width, height)
+	if err != nil {
+		return err
+	}
+	h.enc, h.rect = enc, r
+	h.frame = image.NewRGBA(image.Rect(0, 0, width, height))
+	return nil
+}
+
+// copyFrame copies img into the encoder's frame, repeating the last column and row when
+// the frame is one larger to keep it even.
+func (h *H264Encoding) copyFrame(img *image.RGBA) {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	for y := 0; y < h.frame.Rect.Dy(); y++ {
+		src := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+min(y, height-1)):]
+		dst := h.frame.Pix[y*h.frame.Stride:]
+		copy(dst, src[:width*4])
+		if h.frame.Rect.Dx() > width {
+			copy(dst[width*4:], src[(width-1)*4:width*4])
+		}
+	}
+}
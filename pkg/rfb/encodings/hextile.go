@@ -0,0 +1,109 @@
+package encodings
+
+import (
+	"image"
+	"io"
+
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+const hextileTileSize = 16
+
+// Hextile subencoding flags.
+const (
+	hextileRaw                 = 1
+	hextileBackgroundSpecified = 2
+	hextileForegroundSpecified = 4
+	hextileAnySubrects         = 8
+	hextileSubrectsColoured    = 16
+)
+
+// HextileEncoding implements Hextile. Rectangles are split into 16x16 tiles, each sent as
+// raw pixels or as subrectangles over a background. The background and foreground colours
+// carry over from one tile to the next when they do not change.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#hextile-encoding
+type HextileEncoding struct{}
+
+// Code returns the code for Hextile.
+func (h *HextileEncoding) Code() int32 { return 5 }
+
+// HandleBuffer handles an image sample.
+func (h *HextileEncoding) HandleBuffer(w io.Writer, f *types.PixelFormat, img *image.RGBA) {
+	var out []byte
+	encodeHextile(f, img, func(mask byte, data []byte) {
+		out = append(out, mask)
+		out = append(out, data...)
+	})
+	_, _ = w.Write(out)
+}
+
+// encodeHextile splits the image into tiles and calls emit with each tile's subencoding
+// mask and the data following it. Raw tiles are passed with just the hextileRaw flag.
+func encodeHextile(f *types.PixelFormat, img *image.RGBA, emit func(mask byte, data []byte)) {
+	b := img.Bounds()
+	size := int(f.BPP) / 8
+
+	var bg, fg uint32
+	var bgValid, fgValid bool
+	for ty := b.Min.Y; ty < b.Max.Y; ty += hextileTileSize {
+		for tx := b.Min.X; tx < b.Max.X; tx += hextileTileSize {
+			r := image.Rect(tx, ty, tx+hextileTileSize, ty+hextileTileSize).Intersect(b)
+			pixels := pixelValues(f, img.SubImage(r).(*image.RGBA))
+			raw := appendPixels(nil, f, pixels, size)
+
+			tileBg, subrects := findSubrects(pixels, r.Dx(), r.Dy())
+			mask := byte(0)
+			var tile []byte
+			if !bgValid || tileBg != bg {
+				mask |= hextileBackgroundSpecified
+				tile = appendCPixel(tile, f, tileBg, size)
+			}
+			if len(subrects) > 0 {
+				mask |= hextileAnySubrects
+				coloured := false
+				for _, s := range subrects[1:] {
+					if s.colour != subrects[0].colour {
+						coloured = true
+						break
+					}
+				}
+				if coloured {
+					mask |= hextileSubrectsColoured
+				} else if !fgValid || subrects[0].colour != fg {
+					mask |= hextileForegroundSpecified
+					tile = appendCPixel(tile, f, subrects[0].colour, size)
+				}
+				tile = append(tile, byte(min(len(subrects), 255)))
+				for _, s := range subrects {
+					if coloured {
+						tile = appendCPixel(tile, f, s.colour, size)
+					}
+					tile = append(tile, byte(s.x<<4|s.y), byte((s.w-1)<<4|(s.h-1)))
+				}
+			}
+
+			// Raw is sent when it is smaller, or there are too many subrectangles to count.
+			if len(subrects) > 255 || len(tile) > len(raw) {
+				emit(hextileRaw, raw)
+				bgValid, fgValid = false, false
+				continue
+			}
+			emit(mask, tile)
+			bg, bgValid = tileBg, true
+			if mask&hextileSubrectsColoured != 0 {
+				// The foreground is undefined after a tile with coloured subrectangles.
+				fgValid = false
+			} else if len(subrects) > 0 {
+				fg, fgValid = subrects[0].colour, true
+			}
+		}
+	}
+}
+
+// appendPixels appends pixel values of the given size in the client's byte order.
+func appendPixels(dst []byte, f *types.PixelFormat, pixels []uint32, size int) []byte {
+	for _, p := range pixels {
+		dst = appendCPixel(dst, f, p, size)
+	}
+	return dst
+}
@@ -0,0 +1,123 @@
+package encodings
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"testing"
+
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// hextileDecoder keeps the background and foreground colours that carry over from one tile
+// to the next, and counts the kinds of tile it has seen.
+type hextileDecoder struct {
+	bg, fg           uint32
+	bgValid, fgValid bool
+	seen             map[string]int
+}
+
+// decode reads the tiles of a rectangle, each with its subencoding mask.
+func (d *hextileDecoder) decode(r *bytes.Reader, f *types.PixelFormat, width, height int) ([]uint32, error) {
+	return decodeTiles(width, height, hextileTileSize, func(pixels []uint32, tile image.Rectangle) error {
+		mask, err := readByte(r)
+		if err != nil {
+			return err
+		}
+		return d.decodeTile(r, f, mask, pixels, width, tile)
+	})
+}
+
+// decodeTile reads the data following a tile's subencoding mask.
+func (d *hextileDecoder) decodeTile(r io.Reader, f *types.PixelFormat, mask byte, pixels []uint32, width int, tile image.Rectangle) error {
+	size := int(f.BPP) / 8
+	if mask&hextileRaw != 0 {
+		d.seen["raw"]++
+		// The colours are undefined after a raw tile.
+		d.bgValid, d.fgValid = false, false
+		for y := tile.Min.Y; y < tile.Max.Y; y++ {
+			for x := tile.Min.X; x < tile.Max.X; x++ {
+				v, err := readPixel(r, f, size)
+				if err != nil {
+					return err
+				}
+				pixels[y*width+x] = v
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if mask&hextileBackgroundSpecified != 0 {
+		if d.bg, err = readPixel(r, f, size); err != nil {
+			return err
+		}
+		d.bgValid = true
+	} else if !d.bgValid {
+		return errors.New("tile uses an undefined background")
+	}
+	if mask&hextileForegroundSpecified != 0 {
+		if d.fg, err = readPixel(r, f, size); err != nil {
+			return err
+		}
+		d.fgValid = true
+	}
+	if err := fillRect(pixels, width, tile, d.bg); err != nil {
+		return err
+	}
+	if mask&hextileAnySubrects == 0 {
+		d.seen["solid"]++
+		return nil
+	}
+
+	n, err := readByte(r)
+	if err != nil {
+		return err
+	}
+	coloured := mask&hextileSubrectsColoured != 0
+	if coloured {
+		d.seen["coloured subrectangles"]++
+		d.fgValid = false
+	} else if !d.fgValid {
+		return errors.New("subrectangles use an undefined foreground")
+	} else {
+		d.seen["subrectangles"]++
+	}
+	for range n {
+		colour := d.fg
+		if coloured {
+			if colour, err = readPixel(r, f, size); err != nil {
+				return err
+			}
+		}
+		var xy, wh [1]byte
+		if _, err := io.ReadFull(r, xy[:]); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, wh[:]); err != nil {
+			return err
+		}
+		x, y := tile.Min.X+int(xy[0]>>4), tile.Min.Y+int(xy[0]&15)
+		sub := image.Rect(x, y, x+int(wh[0]>>4)+1, y+int(wh[0]&15)+1)
+		if !sub.In(tile) {
+			return errors.New("subrectangle outside its tile")
+		}
+		if err := fillRect(pixels, width, sub, colour); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestHextileRoundTrip(t *testing.T) {
+	seen := map[string]int{}
+	checkRoundTrip(t, func() Encoding { return &HextileEncoding{} }, func() rectDecoder {
+		return (&hextileDecoder{seen: seen}).decode
+	})
+	for _, kind := range []string{"raw", "solid", "subrectangles", "coloured subrectangles"} {
+		if seen[kind] == 0 {
+			t.Errorf("no %s tiles were sent", kind)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package encodings
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math/bits"
+	"sync"
+
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// ValidatePixelFormat returns an error if a pixel format is not one the protocol allows, or a
+// colour-mapped one too shallow for our colour map. Pixels are 8, 16 or 32 bits, and in true
+// colour formats each colour is a whole number of bits fitting in the pixel at its shift.
+func ValidatePixelFormat(f *types.PixelFormat) error {
+	switch f.BPP {
+	case 8, 16, 32:
+	default:
+		return fmt.Errorf("unsupported bits per pixel %d", f.BPP)
+	}
+	if f.Depth == 0 || f.Depth > f.BPP {
+		return fmt.Errorf("invalid depth %d for %d bits per pixel", f.Depth, f.BPP)
+	}
+	if f.TrueColour == 0 {
+		if f.Depth < 8 {
+			return fmt.Errorf("colour map needs a depth of at least 8, not %d", f.Depth)
+		}
+		return nil
+	}
+	for _, c := range []struct {
+		name  string
+		max   uint16
+		shift uint8
+	}{
+		{"red", f.RedMax, f.RedShift},
+		{"green", f.GreenMax, f.GreenShift},
+		{"blue", f.BlueMax, f.BlueShift},
+	} {
+		if c.max == 0 || c.max&(c.max+1) != 0 {
+			return fmt.Errorf("%s max %d is not one less than a power of two", c.name, c.max)
+		}
+		if int(c.shift)+bits.Len16(c.max) > int(f.BPP) {
+			return fmt.Errorf("%s does not fit in %d bits per pixel", c.name, f.BPP)
+		}
+	}
+	if colourMask(f) != uint32(f.RedMax)<<f.RedShift^uint32(f.GreenMax)<<f.GreenShift^uint32(f.BlueMax)<<f.BlueShift {
+		return errors.New("colours overlap")
+	}
+	return nil
+}
+
+// pixelConverter converts RGBA pixels to a pixel format. Each 8-bit channel is scaled and
+// shifted through a lookup table, and the values added together. For colour-mapped formats
+// that gives the index of the nearest colour in the colour cube.
+type pixelConverter struct {
+	f                *types.PixelFormat
+	red, green, blue [256]uint32
+
+	// byteOrder is set when each channel takes a whole byte of a 32-bit pixel, giving the
+	// position of red, green and blue in the bytes sent. This covers the common RGB888 and
+	// BGR888 layouts, which can then be copied byte by byte.
+	byteOrder *[3]int
+}
+
+// maxConverters is how many converters are kept. Clients can ask for any number of formats,
+// but few are used at a time, so the cache is emptied when it fills up.
+const maxConverters = 16
+
+var (
+	convertersMu sync.Mutex
+	converters   = map[types.PixelFormat]*pixelConverter{}
+)
+
+// converterFor returns the converter for a pixel format, building it on first use.
+func converterFor(f *types.PixelFormat) *pixelConverter {
+	convertersMu.Lock()
+	defer convertersMu.Unlock()
+	if c, ok := converters[*f]; ok {
+		return c
+	}
+	c := newPixelConverter(f)
+	if len(converters) >= maxConverters {
+		clear(converters)
+	}
+	converters[*f] = c
+	return c
+}
+
+func newPixelConverter(f *types.PixelFormat) *pixelConverter {
+	format := *f
+	c := &pixelConverter{f: &format}
+	if f.TrueColour == 0 {
+		const levels = colourCubeLevels
+		for v := range 256 {
+			c.red[v] = scaleChannel(uint8(v), levels-1) * levels * levels
+			c.green[v] = scaleChannel(uint8(v), levels-1) * levels
+			c.blue[v] = scaleChannel(uint8(v), levels-1)
+		}
+		return c
+	}
+	for v := range 256 {
+		c.red[v] = scaleChannel(uint8(v), f.RedMax) << f.RedShift
+		c.green[v] = scaleChannel(uint8(v), f.GreenMax) << f.GreenShift
+		c.blue[v] = scaleChannel(uint8(v), f.BlueMax) << f.BlueShift
+	}
+	if f.BPP == 32 && f.RedMax == 0xff && f.GreenMax == 0xff && f.BlueMax == 0xff &&
+		f.RedShift%8 == 0 && f.GreenShift%8 == 0 && f.BlueShift%8 == 0 {
+		var order [3]int
+		for i, shift := range []uint8{f.RedShift, f.GreenShift, f.BlueShift} {
+			order[i] = int(shift / 8)
+			if f.BigEndian != 0 {
+				order[i] = 3 - order[i]
+			}
+		}
+		c.byteOrder = &order
+	}
+	return c
+}
+
+func scaleChannel(v uint8, max uint16) uint32 {
+	return (uint32(v)*uint32(max) + 127) / 255
+}
+
+// appendValues appends the pixel values of the area r of img.
+func (c *pixelConverter) appendValues(dst []uint32, img *image.RGBA, r image.Rectangle) []uint32 {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(r.Min.X, y):][:r.Dx()*4]
+		for i := 0; i < len(row); i += 4 {
+			dst = append(dst, c.red[row[i]]+c.green[row[i+1]]+c.blue[row[i+2]])
+		}
+	}
+	return dst
+}
+
+// appendPixels appends the pixels of img as they are sent in raw form, in the format's size
+// and byte order.
+func (c *pixelConverter) appendPixels(dst []byte, img *image.RGBA) []byte {
+	b := img.Bounds()
+	size := int(c.f.BPP) / 8
+	start := len(dst)
+	dst = append(dst, make([]byte, b.Dx()*b.Dy()*size)...)
+	out := dst[start:]
+	bigEndian := c.f.BigEndian != 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):][:b.Dx()*4]
+		switch {
+		case c.byteOrder != nil:
+			r, g, bl := c.byteOrder[0], c.byteOrder[1], c.byteOrder[2]
+			for i := 0; i < len(row); i += 4 {
+				out[i+r], out[i+g], out[i+bl] = row[i], row[i+1], row[i+2]
+			}
+		case size == 4:
+			for i := 0; i < len(row); i += 4 {
+				v := c.red[row[i]] + c.green[row[i+1]] + c.blue[row[i+2]]
+				if bigEndian {
+					out[i], out[i+1], out[i+2], out[i+3] = byte(v>>24), byte(v>>16), byte(v>>8), byte(v)
+				} else {
+					out[i], out[i+1], out[i+2], out[i+3] = byte(v), byte(v>>8), byte(v>>16), byte(v>>24)
+				}
+			}
+		case size == 2:
+			for i, j := 0, 0; i < len(row); i, j = i+4, j+2 {
+				v := c.red[row[i]] + c.green[row[i+1]] + c.blue[row[i+2]]
+				if bigEndian {
+					out[j], out[j+1] = byte(v>>8), byte(v)
+				} else {
+					out[j], out[j+1] = byte(v), byte(v>>8)
+				}
+			}
+		default:
+			for i, j := 0, 0; i < len(row); i, j = i+4, j+1 {
+				out[j] = byte(c.red[row[i]] + c.green[row[i+1]] + c.blue[row[i+2]])
+			}
+		}
+		out = out[len(row)/4*size:]
+	}
+	return dst
+}
@@ -0,0 +1,45 @@
+package encodings
+
+import (
+	"testing"
+
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+func TestValidatePixelFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		f       types.PixelFormat
+		wantErr bool
+	}{
+		{"rgb888", types.PixelFormat{BPP: 32, Depth: 24, TrueColour: 1, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8}, false},
+		{"rgb565", types.PixelFormat{BPP: 16, Depth: 16, TrueColour: 1, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}, false},
+		{"bgr233", types.PixelFormat{BPP: 8, Depth: 8, TrueColour: 1, RedMax: 7, GreenMax: 7, BlueMax: 3, GreenShift: 3, BlueShift: 6}, false},
+		{"colour map", types.PixelFormat{BPP: 8, Depth: 8}, false},
+		{"24 bits per pixel", types.PixelFormat{BPP: 24, Depth: 24, TrueColour: 1, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8}, true},
+		{"depth too large", types.PixelFormat{BPP: 16, Depth: 24, TrueColour: 1, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}, true},
+		{"shallow colour map", types.PixelFormat{BPP: 8, Depth: 4}, true},
+		{"max not a power of two", types.PixelFormat{BPP: 16, Depth: 16, TrueColour: 1, RedMax: 30, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}, true},
+		{"colour past the pixel", types.PixelFormat{BPP: 16, Depth: 16, TrueColour: 1, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 12, GreenShift: 5}, true},
+		{"colours overlap", types.PixelFormat{BPP: 16, Depth: 16, TrueColour: 1, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 10, GreenShift: 5}, true},
+	}
+	for _, tt := range tests {
+		if err := ValidatePixelFormat(&tt.f); (err != nil) != tt.wantErr {
+			t.Errorf("%s: ValidatePixelFormat() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestConverterCacheIsBounded(t *testing.T) {
+	for shift := uint8(0); shift <= 24; shift++ {
+		f := types.PixelFormat{BPP: 32, Depth: 8, TrueColour: 1, RedMax: 3, GreenMax: 3, BlueMax: 3, RedShift: shift, GreenShift: shift + 2, BlueShift: shift + 4}
+		if c := converterFor(&f); *c.f != f {
+			t.Fatalf("converterFor() returned a converter for %+v, want %+v", *c.f, f)
+		}
+	}
+	convertersMu.Lock()
+	defer convertersMu.Unlock()
+	if len(converters) > maxConverters {
+		t.Errorf("%d converters cached, want at most %d", len(converters), maxConverters)
+	}
+}
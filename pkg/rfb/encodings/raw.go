@@ -15,5 +15,5 @@ func (r *RawEncoding) Code() int32 { return 0 }
 
 // HandleBuffer handles an image sample.
 func (r *RawEncoding) HandleBuffer(w io.Writer, f *types.PixelFormat, img *image.RGBA) {
-	_, _ = w.Write(applyPixelFormat(img, f))
+	_, _ = w.Write(converterFor(f).appendPixels(nil, img))
 }
@@ -0,0 +1,122 @@
+package encodings
+
+import (
+	"image"
+	"io"
+
+	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// RREEncoding implements RRE, which sends a background colour followed by rectangles of
+// other colours drawn over it.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#rre-encoding
+type RREEncoding struct{}
+
+// Code returns the code for RRE.
+func (r *RREEncoding) Code() int32 { return 2 }
+
+// HandleBuffer handles an image sample.
+func (r *RREEncoding) HandleBuffer(w io.Writer, f *types.PixelFormat, img *image.RGBA) {
+	b := img.Bounds()
+	pixels := pixelValues(f, img)
+	bg, subrects := findSubrects(pixels, b.Dx(), b.Dy())
+
+	size := int(f.BPP) / 8
+	util.Write(w, uint32(len(subrects)))
+	out := appendCPixel(make([]byte, 0, size+len(subrects)*(size+8)), f, bg, size)
+	for _, s := range subrects {
+		out = appendCPixel(out, f, s.colour, size)
+		out = append(out,
+			byte(s.x>>8), byte(s.x), byte(s.y>>8), byte(s.y),
+			byte(s.w>>8), byte(s.w), byte(s.h>>8), byte(s.h))
+	}
+	_, _ = w.Write(out)
+}
+
+// CoRREEncoding implements CoRRE, a variant of RRE limited to rectangles of 255x255 with
+// single byte subrectangle coordinates.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#corre-encoding
+type CoRREEncoding struct{}
+
+// Code returns the code for CoRRE.
+func (c *CoRREEncoding) Code() int32 { return 4 }
+
+// RectLimits returns the largest rectangle CoRRE can send.
+func (c *CoRREEncoding) RectLimits() (maxWidth, maxHeight, maxArea int) { return 255, 255, 0 }
+
+// HandleBuffer handles an image sample.
+func (c *CoRREEncoding) HandleBuffer(w io.Writer, f *types.PixelFormat, img *image.RGBA) {
+	b := img.Bounds()
+	pixels := pixelValues(f, img)
+	bg, subrects := findSubrects(pixels, b.Dx(), b.Dy())
+
+	size := int(f.BPP) / 8
+	util.Write(w, uint32(len(subrects)))
+	out := appendCPixel(make([]byte, 0, size+len(subrects)*(size+4)), f, bg, size)
+	for _, s := range subrects {
+		out = appendCPixel(out, f, s.colour, size)
+		out = append(out, byte(s.x), byte(s.y), byte(s.w), byte(s.h))
+	}
+	_, _ = w.Write(out)
+}
+
+// subrect is a rectangle of a single colour, relative to the rectangle being encoded.
+type subrect struct {
+	colour     uint32
+	x, y, w, h int
+}
+
+// pixelValues converts an image to pixel values in the given format.
+func pixelValues(f *types.PixelFormat, img *image.RGBA) []uint32 {
+	b := img.Bounds()
+	return converterFor(f).appendValues(make([]uint32, 0, b.Dx()*b.Dy()), img, b)
+}
+
+// findSubrects picks the most common colour as the background and covers every other pixel
+// with rectangles of a single colour. Each rectangle is grown right and then down from the
+// first pixel not yet covered. The given pixels are overwritten.
+func findSubrects(pixels []uint32, width, height int) (bg uint32, subrects []subrect) {
+	bg = mostCommonColour(pixels)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			colour := pixels[y*width+x]
+			if colour == bg {
+				continue
+			}
+			w := 1
+			for x+w < width && pixels[y*width+x+w] == colour {
+				w++
+			}
+			h := 1
+		grow:
+			for y+h < height {
+				for _, p := range pixels[(y+h)*width+x : (y+h)*width+x+w] {
+					if p != colour {
+						break grow
+					}
+				}
+				h++
+			}
+			subrects = append(subrects, subrect{colour: colour, x: x, y: y, w: w, h: h})
+			for sy := y; sy < y+h; sy++ {
+				for sx := x; sx < x+w; sx++ {
+					pixels[sy*width+sx] = bg
+				}
+			}
+		}
+	}
+	return bg, subrects
+}
+
+func mostCommonColour(pixels []uint32) uint32 {
+	counts := make(map[uint32]int)
+	var best uint32
+	for _, p := range pixels {
+		counts[p]++
+		if counts[p] > counts[best] {
+			best = p
+		}
+	}
+	return best
+}
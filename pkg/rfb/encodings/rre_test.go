@@ -0,0 +1,61 @@
+package encodings
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"testing"
+
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// decodeRRE returns a decoder for RRE, with two byte subrectangle coordinates, or CoRRE,
+// with one byte coordinates.
+func decodeRRE(coordSize int) func() rectDecoder {
+	return func() rectDecoder {
+		return func(r *bytes.Reader, f *types.PixelFormat, width, height int) ([]uint32, error) {
+			size := int(f.BPP) / 8
+			var n uint32
+			if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+				return nil, err
+			}
+			bg, err := readPixel(r, f, size)
+			if err != nil {
+				return nil, err
+			}
+			pixels := make([]uint32, width*height)
+			if err := fillRect(pixels, width, image.Rect(0, 0, width, height), bg); err != nil {
+				return nil, err
+			}
+			coords := make([]byte, 4*coordSize)
+			for range n {
+				colour, err := readPixel(r, f, size)
+				if err != nil {
+					return nil, err
+				}
+				if _, err := io.ReadFull(r, coords); err != nil {
+					return nil, err
+				}
+				var c [4]int
+				for i := range c {
+					for _, b := range coords[i*coordSize : (i+1)*coordSize] {
+						c[i] = c[i]<<8 | int(b)
+					}
+				}
+				if err := fillRect(pixels, width, image.Rect(c[0], c[1], c[0]+c[2], c[1]+c[3]), colour); err != nil {
+					return nil, err
+				}
+			}
+			return pixels, nil
+		}
+	}
+}
+
+func TestRRERoundTrip(t *testing.T) {
+	checkRoundTrip(t, func() Encoding { return &RREEncoding{} }, decodeRRE(2))
+}
+
+func TestCoRRERoundTrip(t *testing.T) {
+	checkRoundTrip(t, func() Encoding { return &CoRREEncoding{} }, decodeRRE(1))
+}
@@ -0,0 +1,93 @@
+package encodings
+
+// Pseudo-encodings a client uses to tune lossy and zlib compression.
+const (
+	pseudoQualityLevel0     = -32
+	pseudoQualityLevel9     = -23
+	pseudoFineQuality0      = -512
+	pseudoFineQuality100    = -412
+	pseudoSubsampling1X     = -768
+	pseudoSubsampling16X    = -763
+	pseudoCompressLevel0    = -256
+	pseudoCompressLevel9    = -247
+	defaultCompressionLevel = -1
+)
+
+// qualityLevels maps the coarse quality levels 0 to 9 to JPEG qualities, as TigerVNC does.
+var qualityLevels = [10]int{15, 29, 41, 42, 62, 77, 79, 86, 92, 100}
+
+// Subsampling is the chroma subsampling a client asks for in JPEG images.
+type Subsampling int
+
+// Subsampling levels, in the order of their pseudo-encodings.
+const (
+	Subsampling1X Subsampling = iota // 4:4:4
+	Subsampling4X                    // 4:2:0
+	Subsampling2X                    // 4:2:2
+	SubsamplingGrey
+	Subsampling8X
+	Subsampling16X
+)
+
+// Settings are the compression options a client asks for with pseudo-encodings.
+type Settings struct {
+	// JPEGQuality is the JPEG quality from 1 to 100, or 0 if the client didn't ask for lossy
+	// compression.
+	JPEGQuality int
+	// Subsampling is the chroma subsampling for JPEG images.
+	Subsampling Subsampling
+	// CompressLevel is the zlib compression level from 0 to 9, or -1 for the default.
+	CompressLevel int
+}
+
+// Configurable is implemented by encodings that change what they send depending on the
+// client's settings. Configure is called whenever the client sets its encodings, which may
+// be while HandleBuffer is running on another goroutine.
+type Configurable interface {
+	Encoding
+	Configure(s Settings)
+}
+
+// SettingsPseudoEncodings returns the first code of each range of pseudo-encodings the
+// given encodings take settings from: the compression level for those that are
+// Configurable, and the JPEG quality for Tight.
+func SettingsPseudoEncodings(encs []Encoding) []int32 {
+	var level, quality bool
+	for _, enc := range encs {
+		_, configurable := enc.(Configurable)
+		_, tight := enc.(*TightEncoding)
+		level = level || configurable
+		quality = quality || tight
+	}
+	var out []int32
+	if level {
+		out = append(out, pseudoCompressLevel0)
+	}
+	if quality {
+		out = append(out, pseudoQualityLevel0)
+	}
+	return out
+}
+
+// ParseSettings reads the settings from the encodings a client set. A fine-grained quality
+// takes precedence over a coarse one.
+func ParseSettings(encs []int32) Settings {
+	s := Settings{Subsampling: Subsampling4X, CompressLevel: defaultCompressionLevel}
+	fine := 0
+	for _, e := range encs {
+		switch {
+		case e >= pseudoQualityLevel0 && e <= pseudoQualityLevel9:
+			s.JPEGQuality = qualityLevels[e-pseudoQualityLevel0]
+		case e >= pseudoFineQuality0 && e <= pseudoFineQuality100:
+			fine = max(int(e-pseudoFineQuality0), 1)
+		case e >= pseudoSubsampling1X && e <= pseudoSubsampling16X:
+			s.Subsampling = Subsampling(e - pseudoSubsampling1X)
+		case e >= pseudoCompressLevel0 && e <= pseudoCompressLevel9:
+			s.CompressLevel = int(e - pseudoCompressLevel0)
+		}
+	}
+	if fine != 0 {
+		s.JPEGQuality = fine
+	}
+	return s
+}
@@ -3,56 +3,347 @@ package encodings
 import (
 	"bytes"
 	"image"
+	"image/draw"
 	"image/jpeg"
 	"io"
 	"sync"
 
+	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
 	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
 	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
 )
 
-// TightOptions lets you tune JPEG compression quality (1..100).
+// TightOptions configures Tight on the server.
 type TightOptions struct {
+	// JPEGQuality is the highest JPEG quality (1..100) sent, whatever clients ask for. Zero
+	// or less means no limit.
 	JPEGQuality int
 }
 
-// TightEncoding implements Tight with JPEG compression.
+// Limits on the rectangles Tight can send.
+const (
+	tightMaxRectWidth = 2048
+	tightMaxRectArea  = 65536
+)
+
+// Compression control byte values.
+const (
+	tightFill           = 0x80
+	tightJPEG           = 0x90
+	tightExplicitFilter = 0x40
+)
+
+// Filters applied before basic compression.
+const (
+	tightFilterCopy     = 0
+	tightFilterPalette  = 1
+	tightFilterGradient = 2
+)
+
+// The four zlib streams, one for each kind of data, so each stream sees similar data.
+const (
+	tightStreamFull     = 0
+	tightStreamMono     = 1
+	tightStreamIndexed  = 2
+	tightStreamGradient = 3
+)
+
+const (
+	// tightMinToCompress is the size under which data is sent without compression.
+	tightMinToCompress = 12
+	// tightMaxPalette is the most colours the palette filter can carry.
+	tightMaxPalette = 256
+	// tightMinJPEGArea is the smallest rectangle worth the overhead of a JPEG.
+	tightMinJPEGArea = 1024
+	// tightSmoothThreshold is the mean gradient prediction error, per 8-bit channel, under
+	// which an image is considered smooth, continuous-tone content.
+	tightSmoothThreshold = 32
+)
+
+// TightEncoding implements Tight. Each rectangle is sent as a solid fill, a palette of
+// colours, a JPEG when it looks like a photo and the client allows lossy compression, or zlib
+// compressed full colour pixels. The zlib streams last for the lifetime of the connection,
+// and are restarted when the client changes the compression level.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#tight-encoding
 type TightEncoding struct {
-	quality int
+	maxQuality int
+
+	// mu guards everything below, as the client's settings arrive while rectangles are
+	// being encoded.
+	mu sync.Mutex
+	// set from the client's settings
+	quality int // 0 when JPEG is not allowed
+	grey    bool
+	level   int
+
+	streams [4]zlibStream
+	// resets has a bit set for each stream to restart with the next rectangle
+	resets uint8
+
+	// scratch space reused between rectangles
+	pixels  []uint32
+	data    []byte
+	palette map[uint32]uint8
+	colours []uint32
 }
 
 // NewTight constructs a Tight encoder with options.
 func NewTight(opts TightOptions) *TightEncoding {
-	q := opts.JPEGQuality
-	if q <= 0 {
-		q = 75
-	}
-	if q > 100 {
-		q = 100
-	}
-	return &TightEncoding{quality: q}
+	return &TightEncoding{maxQuality: min(max(opts.JPEGQuality, 0), 100), level: defaultCompressionLevel}
 }
 
 // Code returns the RFB encoding code for Tight.
 func (t *TightEncoding) Code() int32 { return 7 }
 
-// HandleBuffer JPEG-encodes the RGBA frame and writes Tight payload.
-// Layout: [control byte=0x90] [varlen length] [JPEG bytes]
+// NewConnection returns an encoder with the same options and its own zlib streams.
+func (t *TightEncoding) NewConnection() Encoding {
+	return &TightEncoding{maxQuality: t.maxQuality, level: defaultCompressionLevel}
+}
+
+// Configure applies the client's JPEG quality, subsampling and compression level. Streams
+// that have started at a different level are restarted.
+func (t *TightEncoding) Configure(s Settings) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.quality = s.JPEGQuality
+	if t.maxQuality > 0 {
+		t.quality = min(t.quality, t.maxQuality)
+	}
+	// The JPEG encoder always subsamples colour 4:2:0. Clients asking for less subsampling
+	// than that get lossless rectangles instead, and those asking for more get 4:2:0.
+	if s.Subsampling == Subsampling1X || s.Subsampling == Subsampling2X {
+		t.quality = 0
+	}
+	t.grey = s.Subsampling == SubsamplingGrey
+	t.level = s.CompressLevel
+	for i := range t.streams {
+		if !t.streams[i].setLevel(t.level) {
+			t.resets |= 1 << i
+		}
+	}
+}
+
+// RectLimits returns the largest rectangle Tight can send.
+func (t *TightEncoding) RectLimits() (maxWidth, maxHeight, maxArea int) {
+	return tightMaxRectWidth, 0, tightMaxRectArea
+}
+
 var jpegPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
 
+// HandleBuffer picks the best way to send the rectangle and writes its Tight payload.
 func (t *TightEncoding) HandleBuffer(w io.Writer, f *types.PixelFormat, img *image.RGBA) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.palette == nil {
+		t.palette = make(map[uint32]uint8, tightMaxPalette)
+	}
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	// Convert to the client's format and gather the palette, giving up once it is too large.
+	pixels := converterFor(f).appendValues(t.pixels[:0], img, b)
+	clear(t.palette)
+	t.colours = t.colours[:0]
+	for _, v := range pixels {
+		if len(t.colours) > tightMaxPalette {
+			break
+		}
+		if _, ok := t.palette[v]; !ok {
+			t.palette[v] = uint8(len(t.colours))
+			t.colours = append(t.colours, v)
+		}
+	}
+	t.pixels = pixels
+
+	switch n := len(t.colours); {
+	case n == 1:
+		t.writeControl(w, tightFill)
+		_, _ = w.Write(appendTPixel(nil, f, pixels[0]))
+	case n <= tightMaxPalette && n <= width*height/2:
+		t.writePalette(w, f, width, height)
+	case f.TrueColour != 0 && f.BPP >= 16 && t.isSmooth(f, width, height):
+		if t.quality > 0 && width*height >= tightMinJPEGArea && t.writeJPEG(w, img) {
+			return
+		}
+		t.writeGradient(w, f, width)
+	default:
+		t.data = t.data[:0]
+		for _, p := range pixels {
+			t.data = appendTPixel(t.data, f, p)
+		}
+		t.writeControl(w, tightStreamFull<<4)
+		t.compress(w, tightStreamFull, t.data)
+	}
+}
+
+func (t *TightEncoding) writePalette(w io.Writer, f *types.PixelFormat, width, height int) {
+	stream := tightStreamIndexed
+	if len(t.colours) == 2 {
+		stream = tightStreamMono
+	}
+	t.writeControl(w, uint8(stream<<4|tightExplicitFilter))
+	util.Write(w, uint8(tightFilterPalette))
+	util.Write(w, uint8(len(t.colours)-1))
+	var pal []byte
+	for _, c := range t.colours {
+		pal = appendTPixel(pal, f, c)
+	}
+	_, _ = w.Write(pal)
+
+	t.data = t.data[:0]
+	if stream == tightStreamIndexed {
+		for _, p := range t.pixels {
+			t.data = append(t.data, t.palette[p])
+		}
+	} else {
+		// One bit per pixel, rows padded to a whole byte.
+		for y := 0; y < height; y++ {
+			var cur byte
+			for x, p := range t.pixels[y*width : (y+1)*width] {
+				cur |= t.palette[p] << (7 - x%8)
+				if x%8 == 7 {
+					t.data = append(t.data, cur)
+					cur = 0
+				}
+			}
+			if width%8 != 0 {
+				t.data = append(t.data, cur)
+			}
+		}
+	}
+	t.compress(w, stream, t.data)
+}
+
+func (t *TightEncoding) writeJPEG(w io.Writer, img *image.RGBA) bool {
 	jb := jpegPool.Get().(*bytes.Buffer)
 	jb.Reset()
 	defer jpegPool.Put(jb)
-	if err := jpeg.Encode(jb, img, &jpeg.Options{Quality: t.quality}); err != nil {
-		return // drop frame on error
+	var src image.Image = img
+	if t.grey {
+		grey := image.NewGray(img.Bounds())
+		draw.Draw(grey, grey.Bounds(), img, img.Bounds().Min, draw.Src)
+		src = grey
+	}
+	if err := jpeg.Encode(jb, src, &jpeg.Options{Quality: t.quality}); err != nil {
+		log.Error("Could not encode JPEG: ", err)
+		return false
+	}
+	t.writeControl(w, tightJPEG)
+	_, _ = w.Write(computeTightLength(jb.Len()))
+	_, _ = w.Write(jb.Bytes())
+	return true
+}
+
+func (t *TightEncoding) writeGradient(w io.Writer, f *types.PixelFormat, width int) {
+	t.writeControl(w, tightStreamGradient<<4|tightExplicitFilter)
+	util.Write(w, uint8(tightFilterGradient))
+
+	maxes := [3]uint32{uint32(f.RedMax), uint32(f.GreenMax), uint32(f.BlueMax)}
+	shifts := [3]uint8{f.RedShift, f.GreenShift, f.BlueShift}
+	tpixel24 := isTPixel24(f)
+	t.data = t.data[:0]
+	for i := range t.pixels {
+		pred := gradientPrediction(t.pixels, width, i, maxes, shifts)
+		var diff uint32
+		for c := range maxes {
+			d := ((t.pixels[i]>>shifts[c])&maxes[c] - pred[c]) & maxes[c]
+			if tpixel24 {
+				t.data = append(t.data, byte(d))
+			}
+			diff |= d << shifts[c]
+		}
+		if !tpixel24 {
+			t.data = appendCPixel(t.data, f, diff, int(f.BPP)/8)
+		}
+	}
+	t.compress(w, tightStreamGradient, t.data)
+}
+
+// isSmooth reports whether the pixels look like continuous-tone content, such as a photo.
+// Text and user interfaces have large areas the gradient predicts exactly and sharp edges
+// between them, while photos are predicted closely but rarely exactly.
+func (t *TightEncoding) isSmooth(f *types.PixelFormat, width, height int) bool {
+	maxes := [3]uint32{uint32(f.RedMax), uint32(f.GreenMax), uint32(f.BlueMax)}
+	shifts := [3]uint8{f.RedShift, f.GreenShift, f.BlueShift}
+	var exact, errSum int
+	for i, p := range t.pixels {
+		pred := gradientPrediction(t.pixels, width, i, maxes, shifts)
+		pixelErr := 0
+		for c := range maxes {
+			d := int((p>>shifts[c])&maxes[c]) - int(pred[c])
+			if d < 0 {
+				d = -d
+			}
+			pixelErr += d * 255 / max(int(maxes[c]), 1)
+		}
+		if pixelErr == 0 {
+			exact++
+		}
+		errSum += pixelErr
+	}
+	n := width * height
+	return exact*2 < n && errSum/(3*n) < tightSmoothThreshold
+}
+
+// gradientPrediction predicts the colour components of pixel i from its neighbours to the
+// left and above, as the Tight gradient filter does.
+func gradientPrediction(pixels []uint32, width, i int, maxes [3]uint32, shifts [3]uint8) [3]uint32 {
+	x, y := i%width, i/width
+	var left, up, upLeft uint32
+	if x > 0 {
+		left = pixels[i-1]
+	}
+	if y > 0 {
+		up = pixels[i-width]
+		if x > 0 {
+			upLeft = pixels[i-width-1]
+		}
+	}
+	var pred [3]uint32
+	for c := range maxes {
+		v := int((left>>shifts[c])&maxes[c]) + int((up>>shifts[c])&maxes[c]) - int((upLeft>>shifts[c])&maxes[c])
+		pred[c] = uint32(min(max(v, 0), int(maxes[c])))
+	}
+	return pred
+}
+
+// writeControl writes a compression control byte, telling the client to reset any streams
+// that are being restarted.
+func (t *TightEncoding) writeControl(w io.Writer, control uint8) {
+	for i := range t.streams {
+		if t.resets&(1<<i) != 0 {
+			t.streams[i] = zlibStream{}
+			t.streams[i].setLevel(t.level)
+		}
 	}
-	jpegBytes := jb.Bytes()
+	util.Write(w, control|t.resets)
+	t.resets = 0
+}
 
-	const tightJPEGCtrl = 0x90
-	_ = util.Write(w, uint8(tightJPEGCtrl))
-	_ = util.Write(w, computeTightLength(len(jpegBytes)))
-	_, _ = w.Write(jpegBytes)
+// compress writes data through one of the zlib streams, preceded by its compressed length.
+// Data too small to be worth compressing is written as it is.
+func (t *TightEncoding) compress(w io.Writer, stream int, data []byte) {
+	if len(data) < tightMinToCompress {
+		_, _ = w.Write(data)
+		return
+	}
+	compressed := t.streams[stream].compress(data)
+	_, _ = w.Write(computeTightLength(len(compressed)))
+	_, _ = w.Write(compressed)
+}
+
+// isTPixel24 reports whether pixels are sent as three bytes of red, green and blue.
+func isTPixel24(f *types.PixelFormat) bool {
+	return f.TrueColour != 0 && f.BPP == 32 && f.Depth == 24 &&
+		f.RedMax == 0xff && f.GreenMax == 0xff && f.BlueMax == 0xff
+}
+
+// appendTPixel appends a pixel value as Tight sends it.
+func appendTPixel(dst []byte, f *types.PixelFormat, v uint32) []byte {
+	if isTPixel24(f) {
+		return append(dst, byte(v>>f.RedShift), byte(v>>f.GreenShift), byte(v>>f.BlueShift))
+	}
+	return appendCPixel(dst, f, v, int(f.BPP)/8)
 }
 
 func computeTightLength(n int) []byte {
@@ -62,7 +353,7 @@ func computeTightLength(n int) []byte {
 		out = append(out, byte((n>>7)&0x7F))
 		if n > 0x3FFF {
 			out[1] |= 0x80
-			out = append(out, byte((n>>14)&0x7F))
+			out = append(out, byte(n>>14))
 		}
 	}
 	return out
@@ -0,0 +1,320 @@
+package encodings
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"testing"
+
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// tightDecoder decodes Tight, counting the ways of sending a rectangle it has seen.
+type tightDecoder struct {
+	streams [4]zlibReader
+	seen    map[string]int
+}
+
+func (d *tightDecoder) decode(r *bytes.Reader, f *types.PixelFormat, width, height int) ([]uint32, error) {
+	control, err := readByte(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := range d.streams {
+		if control&(1<<i) != 0 {
+			d.seen["reset"]++
+			d.streams[i] = zlibReader{}
+		}
+	}
+	n := width * height
+	pixels := make([]uint32, 0, n)
+	switch control >> 4 {
+	case tightFill >> 4:
+		d.seen["fill"]++
+		c, err := readTPixel(r, f)
+		if err != nil {
+			return nil, err
+		}
+		for range n {
+			pixels = append(pixels, c)
+		}
+		return pixels, nil
+	case tightJPEG >> 4:
+		return nil, errors.New("unexpected JPEG")
+	}
+	if control&0x80 != 0 {
+		return nil, fmt.Errorf("invalid control byte %#x", control)
+	}
+
+	stream := int(control>>4) & 3
+	filter := byte(tightFilterCopy)
+	if control&tightExplicitFilter != 0 {
+		if filter, err = readByte(r); err != nil {
+			return nil, err
+		}
+	}
+	tsize := int(f.BPP) / 8
+	if isTPixel24(f) {
+		tsize = 3
+	}
+
+	switch filter {
+	case tightFilterCopy:
+		d.seen["copy"]++
+		data, err := d.readData(r, stream, n*tsize)
+		if err != nil {
+			return nil, err
+		}
+		for range n {
+			c, err := readTPixel(data, f)
+			if err != nil {
+				return nil, err
+			}
+			pixels = append(pixels, c)
+		}
+	case tightFilterPalette:
+		count, err := readByte(r)
+		if err != nil {
+			return nil, err
+		}
+		palette := make([]uint32, int(count)+1)
+		for i := range palette {
+			if palette[i], err = readTPixel(r, f); err != nil {
+				return nil, err
+			}
+		}
+		if len(palette) == 2 {
+			d.seen["mono"]++
+			rowSize := (width + 7) / 8
+			data, err := d.readData(r, stream, rowSize*height)
+			if err != nil {
+				return nil, err
+			}
+			row := make([]byte, rowSize)
+			for range height {
+				if _, err := io.ReadFull(data, row); err != nil {
+					return nil, err
+				}
+				for x := range width {
+					pixels = append(pixels, palette[row[x/8]>>(7-x%8)&1])
+				}
+			}
+			return pixels, nil
+		}
+		d.seen["palette"]++
+		data, err := d.readData(r, stream, n)
+		if err != nil {
+			return nil, err
+		}
+		for range n {
+			idx, err := readByte(data)
+			if err != nil {
+				return nil, err
+			}
+			if int(idx) >= len(palette) {
+				return nil, fmt.Errorf("palette index %d of %d", idx, len(palette))
+			}
+			pixels = append(pixels, palette[idx])
+		}
+	case tightFilterGradient:
+		d.seen["gradient"]++
+		data, err := d.readData(r, stream, n*tsize)
+		if err != nil {
+			return nil, err
+		}
+		return decodeGradient(data, f, width, height)
+	default:
+		return nil, fmt.Errorf("invalid filter %d", filter)
+	}
+	return pixels, nil
+}
+
+// readData reads n bytes of filtered data, sent as they are when short and otherwise
+// through one of the zlib streams, preceded by their compressed length.
+func (d *tightDecoder) readData(r *bytes.Reader, stream, n int) (io.Reader, error) {
+	if n < tightMinToCompress {
+		return r, nil
+	}
+	length, err := readTightLength(r)
+	if err != nil {
+		return nil, err
+	}
+	compressed := make([]byte, length)
+	if _, err := io.ReadFull(r, compressed); err != nil {
+		return nil, err
+	}
+	zr, err := d.streams[stream].feed(compressed)
+	if err != nil {
+		return nil, err
+	}
+	return io.LimitReader(zr, int64(n)), nil
+}
+
+// readTightLength reads a length in one to three bytes, seven bits at a time and then eight.
+func readTightLength(r io.Reader) (int, error) {
+	length := 0
+	for i := 0; i < 3; i++ {
+		b, err := readByte(r)
+		if err != nil {
+			return 0, err
+		}
+		if i == 2 {
+			return length | int(b)<<14, nil
+		}
+		length |= int(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return length, nil
+}
+
+// readTPixel reads a pixel as Tight sends it: three bytes of red, green and blue for 24-bit
+// colour, and otherwise in the client's format.
+func readTPixel(r io.Reader, f *types.PixelFormat) (uint32, error) {
+	if !isTPixel24(f) {
+		return readPixel(r, f, int(f.BPP)/8)
+	}
+	var rgb [3]byte
+	if _, err := io.ReadFull(r, rgb[:]); err != nil {
+		return 0, err
+	}
+	return uint32(rgb[0])<<f.RedShift | uint32(rgb[1])<<f.GreenShift | uint32(rgb[2])<<f.BlueShift, nil
+}
+
+// decodeGradient adds each pixel's difference to the prediction from the pixels to its
+// left and above.
+func decodeGradient(data io.Reader, f *types.PixelFormat, width, height int) ([]uint32, error) {
+	maxes := [3]int{int(f.RedMax), int(f.GreenMax), int(f.BlueMax)}
+	shifts := [3]uint8{f.RedShift, f.GreenShift, f.BlueShift}
+	component := func(v uint32, c int) int { return int(v>>shifts[c]) & maxes[c] }
+	pixels := make([]uint32, width*height)
+	for y := range height {
+		for x := range width {
+			var diff [3]int
+			if isTPixel24(f) {
+				var rgb [3]byte
+				if _, err := io.ReadFull(data, rgb[:]); err != nil {
+					return nil, err
+				}
+				diff = [3]int{int(rgb[0]), int(rgb[1]), int(rgb[2])}
+			} else {
+				v, err := readPixel(data, f, int(f.BPP)/8)
+				if err != nil {
+					return nil, err
+				}
+				for c := range diff {
+					diff[c] = component(v, c)
+				}
+			}
+			var left, up, upLeft uint32
+			if x > 0 {
+				left = pixels[y*width+x-1]
+			}
+			if y > 0 {
+				up = pixels[(y-1)*width+x]
+				if x > 0 {
+					upLeft = pixels[(y-1)*width+x-1]
+				}
+			}
+			var v uint32
+			for c := range maxes {
+				pred := min(max(component(left, c)+component(up, c)-component(upLeft, c), 0), maxes[c])
+				v |= uint32((pred+diff[c])&maxes[c]) << shifts[c]
+			}
+			pixels[y*width+x] = v
+		}
+	}
+	return pixels, nil
+}
+
+func TestTightRoundTrip(t *testing.T) {
+	seen := map[string]int{}
+	checkRoundTrip(t, func() Encoding { return NewTight(TightOptions{}).NewConnection() }, func() rectDecoder {
+		return (&tightDecoder{seen: seen}).decode
+	})
+	for _, kind := range []string{"fill", "mono", "palette", "gradient", "copy"} {
+		if seen[kind] == 0 {
+			t.Errorf("no %s rectangles were sent", kind)
+		}
+	}
+}
+
+func TestTightResetsStreams(t *testing.T) {
+	f := types.PixelFormat{BPP: 32, Depth: 24, TrueColour: 1, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8}
+	enc := NewTight(TightOptions{}).NewConnection().(*TightEncoding)
+	d := &tightDecoder{seen: map[string]int{}}
+	img := testImage(37, 21, testPattern("noise"))
+	want := pixelValues(&f, img)
+	for _, level := range []int{defaultCompressionLevel, 1, 1, 9} {
+		enc.Configure(Settings{CompressLevel: level})
+		var buf bytes.Buffer
+		enc.HandleBuffer(&buf, &f, img)
+		got, err := d.decode(bytes.NewReader(buf.Bytes()), &f, 37, 21)
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		if i := firstDifference(got, want); i >= 0 {
+			t.Fatalf("level %d: pixel %d differs", level, i)
+		}
+	}
+	// Changing to level 1 and then 9 each restart the stream in use.
+	if d.seen["reset"] != 2 {
+		t.Errorf("streams were reset %d times, want 2", d.seen["reset"])
+	}
+}
+
+func TestTightJPEG(t *testing.T) {
+	f := types.PixelFormat{BPP: 32, Depth: 24, TrueColour: 1, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8}
+	img := testImage(70, 65, testPattern("smooth"))
+	for _, tt := range []struct {
+		sub     Subsampling
+		want    string // the kind of image decoded, or "" for a lossless rectangle
+		control byte
+	}{
+		{sub: Subsampling1X, control: tightStreamGradient<<4 | tightExplicitFilter},
+		{sub: Subsampling2X, control: tightStreamGradient<<4 | tightExplicitFilter},
+		{sub: Subsampling4X, want: "4:2:0", control: tightJPEG},
+		{sub: Subsampling8X, want: "4:2:0", control: tightJPEG},
+		{sub: Subsampling16X, want: "4:2:0", control: tightJPEG},
+		{sub: SubsamplingGrey, want: "grey", control: tightJPEG},
+	} {
+		enc := NewTight(TightOptions{}).NewConnection().(*TightEncoding)
+		enc.Configure(Settings{JPEGQuality: 80, Subsampling: tt.sub, CompressLevel: defaultCompressionLevel})
+		var buf bytes.Buffer
+		enc.HandleBuffer(&buf, &f, img)
+
+		r := bytes.NewReader(buf.Bytes())
+		if control, _ := readByte(r); control != tt.control {
+			t.Fatalf("subsampling %d: control byte %#x, want %#x", tt.sub, control, tt.control)
+		}
+		if tt.want == "" {
+			continue
+		}
+		if length, err := readTightLength(r); err != nil || length != r.Len() {
+			t.Fatalf("subsampling %d: length %d, but %d bytes follow", tt.sub, length, r.Len())
+		}
+		decoded, err := jpeg.Decode(r)
+		if err != nil {
+			t.Fatalf("subsampling %d: %v", tt.sub, err)
+		}
+		if decoded.Bounds().Dx() != 70 || decoded.Bounds().Dy() != 65 {
+			t.Errorf("subsampling %d: JPEG is %v", tt.sub, decoded.Bounds())
+		}
+		switch decoded := decoded.(type) {
+		case *image.Gray:
+			if tt.want != "grey" {
+				t.Errorf("subsampling %d: decoded a grey image, want %s", tt.sub, tt.want)
+			}
+		case *image.YCbCr:
+			if tt.want != "4:2:0" || decoded.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+				t.Errorf("subsampling %d: decoded %v, want %s", tt.sub, decoded.SubsampleRatio, tt.want)
+			}
+		default:
+			t.Errorf("subsampling %d: decoded %T", tt.sub, decoded)
+		}
+	}
+}
@@ -1,56 +1,46 @@
 package encodings
 
 import (
-	"bytes"
-	"encoding/binary"
-	"image"
-
 	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
 )
 
-func applyPixelFormat(img *image.RGBA, format *types.PixelFormat) []byte {
-	formattedImage := new(bytes.Buffer)
-	b := img.Bounds()
-	width, height := b.Dx(), b.Dy()
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			r16, g16, b16, _ := img.At(x, y).RGBA()
-			r16 = inRange(r16, format.RedMax)
-			g16 = inRange(g16, format.GreenMax)
-			b16 = inRange(b16, format.BlueMax)
-			var u32 uint32 = (r16 << format.RedShift) |
-				(g16 << format.GreenShift) |
-				(b16 << format.BlueShift)
-
-			var v interface{}
-			switch format.BPP {
-			case 32:
-				v = u32
-			case 16:
-				v = uint16(u32)
-			case 8:
-				v = uint8(u32)
-			}
-
-			if format.BigEndian != 0 {
-				_ = binary.Write(formattedImage, binary.BigEndian, v)
-			} else {
-				_ = binary.Write(formattedImage, binary.LittleEndian, v)
-			}
+// cpixelSize returns the size of a compressed pixel, as used by ZRLE and Tight. A 32bpp
+// true colour format whose colours fit in three of the four bytes sends only those three.
+func cpixelSize(f *types.PixelFormat) int {
+	if f.TrueColour != 0 && f.BPP == 32 && f.Depth <= 24 {
+		if mask := colourMask(f); mask&0xff000000 == 0 || mask&0x000000ff == 0 {
+			return 3
 		}
 	}
-	return formattedImage.Bytes()
+	return int(f.BPP) / 8
+}
+
+func colourMask(f *types.PixelFormat) uint32 {
+	return uint32(f.RedMax)<<f.RedShift | uint32(f.GreenMax)<<f.GreenShift | uint32(f.BlueMax)<<f.BlueShift
 }
 
-func inRange(v uint32, max uint16) uint32 {
-	switch max {
-	case 0x1f: // 5 bits
-		return v >> (16 - 5)
-	case 0x3f: // 6 bits (green in 565)
-		return v >> (16 - 6)
-	case 0xff: // 8 bits
-		return v >> 8
+// appendCPixel appends a pixel value of the given cpixelSize in the client's byte order.
+func appendCPixel(dst []byte, f *types.PixelFormat, v uint32, size int) []byte {
+	if size == 3 {
+		if colourMask(f)&0xff000000 != 0 {
+			v >>= 8
+		}
+		if f.BigEndian != 0 {
+			return append(dst, byte(v>>16), byte(v>>8), byte(v))
+		}
+		return append(dst, byte(v), byte(v>>8), byte(v>>16))
+	}
+	switch size {
+	case 1:
+		return append(dst, byte(v))
+	case 2:
+		if f.BigEndian != 0 {
+			return append(dst, byte(v>>8), byte(v))
+		}
+		return append(dst, byte(v), byte(v>>8))
+	}
+	if f.BigEndian != 0 {
+		return append(dst, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
 	}
-	// Fallback: clamp to 8bpc
-	return v >> 8
+	return append(dst, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
 }
@@ -0,0 +1,86 @@
+package encodings
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"io"
+	"sync"
+
+	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
+	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// zlibStream is a zlib stream that lasts for the lifetime of a connection. Each call to
+// compress ends with a sync flush, so the client can decode the data straight away.
+type zlibStream struct {
+	buf bytes.Buffer
+	zw  *zlib.Writer
+
+	// level is the compression level, fixed once the stream starts. The zero value is
+	// replaced by the default.
+	level    int
+	levelSet bool
+}
+
+// setLevel sets the compression level. It returns false if the stream has already started
+// with a different level.
+func (s *zlibStream) setLevel(level int) bool {
+	if s.zw != nil {
+		return s.level == level
+	}
+	s.level, s.levelSet = level, true
+	return true
+}
+
+// compress returns the compressed data. It is only valid until the next call.
+func (s *zlibStream) compress(data []byte) []byte {
+	if s.zw == nil {
+		if !s.levelSet {
+			s.level = defaultCompressionLevel
+		}
+		// The level has been checked, so this can't fail.
+		s.zw, _ = zlib.NewWriterLevel(&s.buf, s.level)
+	}
+	s.buf.Reset()
+	if _, err := s.zw.Write(data); err != nil {
+		log.Error("Could not compress data: ", err)
+	}
+	if err := s.zw.Flush(); err != nil {
+		log.Error("Could not compress data: ", err)
+	}
+	return s.buf.Bytes()
+}
+
+// ZlibEncoding implements Zlib, raw pixels compressed with a zlib stream that lasts for the
+// lifetime of the connection.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#zlib-encoding
+type ZlibEncoding struct {
+	// mu guards the stream, as the client's settings arrive while rectangles are encoded.
+	mu     sync.Mutex
+	stream zlibStream
+}
+
+// Code returns the code for Zlib.
+func (z *ZlibEncoding) Code() int32 { return 6 }
+
+// NewConnection returns an encoder with its own zlib stream.
+func (z *ZlibEncoding) NewConnection() Encoding { return &ZlibEncoding{} }
+
+// Configure sets the compression level, if the stream hasn't started yet.
+func (z *ZlibEncoding) Configure(s Settings) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.stream.setLevel(s.CompressLevel)
+}
+
+// HandleBuffer handles an image sample.
+func (z *ZlibEncoding) HandleBuffer(w io.Writer, f *types.PixelFormat, img *image.RGBA) {
+	raw := converterFor(f).appendPixels(nil, img)
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	data := z.stream.compress(raw)
+	util.Write(w, uint32(len(data)))
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,53 @@
+package encodings
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// decodeZlib returns a decoder for Zlib, raw pixels through one zlib stream.
+func decodeZlib() rectDecoder {
+	var stream zlibReader
+	return func(r *bytes.Reader, f *types.PixelFormat, width, height int) ([]uint32, error) {
+		compressed, err := readChunk[uint32](r)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := stream.feed(compressed)
+		if err != nil {
+			return nil, err
+		}
+		pixels := make([]uint32, width*height)
+		for i := range pixels {
+			if pixels[i], err = readPixel(zr, f, int(f.BPP)/8); err != nil {
+				return nil, err
+			}
+		}
+		return pixels, nil
+	}
+}
+
+func TestZlibRoundTrip(t *testing.T) {
+	checkRoundTrip(t, func() Encoding { return (&ZlibEncoding{}).NewConnection() }, decodeZlib)
+}
+
+func TestZlibCompressLevel(t *testing.T) {
+	for _, level := range []int{0, 1, 9} {
+		z := (&ZlibEncoding{}).NewConnection().(*ZlibEncoding)
+		z.Configure(Settings{CompressLevel: level})
+		decode := decodeZlib()
+		f := types.PixelFormat{BPP: 32, Depth: 24, TrueColour: 1, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8}
+		img := testImage(37, 21, testPattern("noise"))
+		var buf bytes.Buffer
+		z.HandleBuffer(&buf, &f, img)
+		got, err := decode(bytes.NewReader(buf.Bytes()), &f, 37, 21)
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		if i := firstDifference(got, pixelValues(&f, img)); i >= 0 {
+			t.Errorf("level %d: pixel %d differs", level, i)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package encodings
+
+import (
+	"image"
+	"io"
+	"sync"
+
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// ZlibHex subencoding flags, in addition to the Hextile ones.
+const (
+	zlibHexZlibRaw = 32
+	zlibHexZlibHex = 64
+)
+
+// zlibHexMinCompress is the tile data size under which tiles are sent as plain Hextile.
+const zlibHexMinCompress = 32
+
+// ZlibHexEncoding implements ZlibHex, Hextile whose larger tiles are compressed. Raw tiles
+// and encoded tiles each go through their own zlib stream, both lasting for the lifetime of
+// the connection.
+type ZlibHexEncoding struct {
+	// mu guards the streams, as the client's settings arrive while rectangles are encoded.
+	mu      sync.Mutex
+	raw     zlibStream
+	encoded zlibStream
+}
+
+// Code returns the code for ZlibHex.
+func (z *ZlibHexEncoding) Code() int32 { return 8 }
+
+// NewConnection returns an encoder with its own zlib streams.
+func (z *ZlibHexEncoding) NewConnection() Encoding { return &ZlibHexEncoding{} }
+
+// Configure sets the compression level, if the streams haven't started yet.
+func (z *ZlibHexEncoding) Configure(s Settings) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.raw.setLevel(s.CompressLevel)
+	z.encoded.setLevel(s.CompressLevel)
+}
+
+// HandleBuffer handles an image sample.
+func (z *ZlibHexEncoding) HandleBuffer(w io.Writer, f *types.PixelFormat, img *image.RGBA) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	var out []byte
+	encodeHextile(f, img, func(mask byte, data []byte) {
+		if len(data) < zlibHexMinCompress {
+			out = append(out, mask)
+			out = append(out, data...)
+			return
+		}
+		// Everything after the subencoding byte is replaced by its compressed length and data.
+		var compressed []byte
+		if mask&hextileRaw != 0 {
+			mask = zlibHexZlibRaw
+			compressed = z.raw.compress(data)
+		} else {
+			mask |= zlibHexZlibHex
+			compressed = z.encoded.compress(data)
+		}
+		out = append(out, mask, byte(len(compressed)>>8), byte(len(compressed)))
+		out = append(out, compressed...)
+	})
+	_, _ = w.Write(out)
+}
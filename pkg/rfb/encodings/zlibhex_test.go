@@ -0,0 +1,58 @@
+package encodings
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// zlibHexDecoder decodes ZlibHex, Hextile with larger tiles compressed through one of two
+// zlib streams.
+type zlibHexDecoder struct {
+	hextileDecoder
+	raw, encoded zlibReader
+}
+
+func (d *zlibHexDecoder) decode(r *bytes.Reader, f *types.PixelFormat, width, height int) ([]uint32, error) {
+	return decodeTiles(width, height, hextileTileSize, func(pixels []uint32, tile image.Rectangle) error {
+		mask, err := readByte(r)
+		if err != nil {
+			return err
+		}
+		if mask&(zlibHexZlibRaw|zlibHexZlibHex) == 0 {
+			d.seen["uncompressed"]++
+			return d.decodeTile(r, f, mask, pixels, width, tile)
+		}
+		compressed, err := readChunk[uint16](r)
+		if err != nil {
+			return err
+		}
+		stream := &d.encoded
+		if mask&zlibHexZlibRaw != 0 {
+			d.seen["compressed raw"]++
+			stream, mask = &d.raw, hextileRaw
+		} else {
+			d.seen["compressed"]++
+			mask &^= zlibHexZlibHex
+		}
+		zr, err := stream.feed(compressed)
+		if err != nil {
+			return err
+		}
+		return d.decodeTile(zr, f, mask, pixels, width, tile)
+	})
+}
+
+func TestZlibHexRoundTrip(t *testing.T) {
+	seen := map[string]int{}
+	checkRoundTrip(t, func() Encoding { return (&ZlibHexEncoding{}).NewConnection() }, func() rectDecoder {
+		return (&zlibHexDecoder{hextileDecoder: hextileDecoder{seen: seen}}).decode
+	})
+	for _, kind := range []string{"uncompressed", "compressed raw", "compressed"} {
+		if seen[kind] == 0 {
+			t.Errorf("no %s tiles were sent", kind)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package encodings
+
+import (
+	"image"
+	"io"
+	"sync"
+
+	"github.com/kamrankamilli/gsvnc/pkg/internal/util"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+const (
+	zrleTileSize   = 64
+	zrleMaxPalette = 127
+)
+
+// ZRLE subencodings. Palette sizes are added to the packed palette and palette RLE ones.
+const (
+	zrleRaw        = 0
+	zrleSolid      = 1
+	zrlePlainRLE   = 128
+	zrlePaletteRLE = 128
+)
+
+// ZRLEEncoding implements ZRLE. Rectangles are split into 64x64 tiles, each sent with
+// whichever subencoding is smallest, and everything goes through a zlib stream that lasts
+// for the lifetime of the connection.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#zrle-encoding
+type ZRLEEncoding struct {
+	// mu guards everything below, as the client's settings arrive while rectangles are
+	// being encoded.
+	mu     sync.Mutex
+	stream zlibStream
+
+	// scratch space reused between rectangles
+	tile    []uint32
+	tiles   []byte
+	palette map[uint32]uint8
+	colours []uint32
+}
+
+// Code returns the code for ZRLE.
+func (z *ZRLEEncoding) Code() int32 { return 16 }
+
+// NewConnection returns an encoder with its own zlib stream.
+func (z *ZRLEEncoding) NewConnection() Encoding { return &ZRLEEncoding{} }
+
+// Configure sets the compression level, if the stream hasn't started yet.
+func (z *ZRLEEncoding) Configure(s Settings) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.stream.setLevel(s.CompressLevel)
+}
+
+// HandleBuffer handles an image sample.
+func (z *ZRLEEncoding) HandleBuffer(w io.Writer, f *types.PixelFormat, img *image.RGBA) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.palette == nil {
+		z.palette = make(map[uint32]uint8, zrleMaxPalette)
+	}
+
+	z.tiles = z.tiles[:0]
+	b := img.Bounds()
+	for ty := b.Min.Y; ty < b.Max.Y; ty += zrleTileSize {
+		for tx := b.Min.X; tx < b.Max.X; tx += zrleTileSize {
+			tile := image.Rect(tx, ty, tx+zrleTileSize, ty+zrleTileSize).Intersect(b)
+			z.encodeTile(f, img, tile)
+		}
+	}
+
+	data := z.stream.compress(z.tiles)
+	util.Write(w, uint32(len(data)))
+	_, _ = w.Write(data)
+}
+
+func (z *ZRLEEncoding) encodeTile(f *types.PixelFormat, img *image.RGBA, r image.Rectangle) {
+	width, height := r.Dx(), r.Dy()
+	pixels := converterFor(f).appendValues(z.tile[:0], img, r)
+	z.tile = pixels
+
+	// Build the palette and count runs, giving up on the palette once it is too large.
+	clear(z.palette)
+	z.colours = z.colours[:0]
+	runs := 0
+	for i, p := range pixels {
+		if i == 0 || p != pixels[i-1] {
+			runs++
+		}
+		if len(z.colours) <= zrleMaxPalette {
+			if _, ok := z.palette[p]; !ok {
+				z.palette[p] = uint8(len(z.colours))
+				z.colours = append(z.colours, p)
+			}
+		}
+	}
+
+	size := cpixelSize(f)
+	if len(z.colours) == 1 {
+		z.tiles = append(z.tiles, zrleSolid)
+		z.tiles = appendCPixel(z.tiles, f, pixels[0], size)
+		return
+	}
+
+	// Estimate the size of each subencoding and pick the smallest.
+	best, bestSize := zrleRaw, size*width*height
+	if plain := (size + 1) * runs; plain < bestSize {
+		best, bestSize = zrlePlainRLE, plain
+	}
+	if n := len(z.colours); n <= zrleMaxPalette {
+		if paletteRLE := size*n + 2*runs; paletteRLE < bestSize {
+			best, bestSize = zrlePaletteRLE+n, paletteRLE
+		}
+		if n <= 16 {
+			packed := size*n + (width*zrlePackedBits(n)+7)/8*height
+			if packed < bestSize {
+				best = n
+			}
+		}
+	}
+
+	switch {
+	case best == zrleRaw:
+		z.tiles = append(z.tiles, zrleRaw)
+		for _, p := range pixels {
+			z.tiles = appendCPixel(z.tiles, f, p, size)
+		}
+	case best == zrlePlainRLE:
+		z.tiles = append(z.tiles, zrlePlainRLE)
+		for i := 0; i < len(pixels); {
+			n := zrleRunLength(pixels[i:])
+			z.tiles = appendCPixel(z.tiles, f, pixels[i], size)
+			z.tiles = appendZRLERunLength(z.tiles, n)
+			i += n
+		}
+	case best > zrlePaletteRLE:
+		z.tiles = append(z.tiles, byte(best))
+		z.appendPalette(f, size)
+		for i := 0; i < len(pixels); {
+			n := zrleRunLength(pixels[i:])
+			idx := z.palette[pixels[i]]
+			if n == 1 {
+				z.tiles = append(z.tiles, idx)
+			} else {
+				z.tiles = append(z.tiles, idx|0x80)
+				z.tiles = appendZRLERunLength(z.tiles, n)
+			}
+			i += n
+		}
+	default:
+		z.tiles = append(z.tiles, byte(best))
+		z.appendPalette(f, size)
+		bits := zrlePackedBits(best)
+		for y := 0; y < height; y++ {
+			var cur byte
+			used := 0
+			for _, p := range pixels[y*width : (y+1)*width] {
+				cur |= z.palette[p] << (8 - bits - used)
+				used += bits
+				if used == 8 {
+					z.tiles = append(z.tiles, cur)
+					cur, used = 0, 0
+				}
+			}
+			// Rows are padded to a whole byte.
+			if used > 0 {
+				z.tiles = append(z.tiles, cur)
+			}
+		}
+	}
+}
+
+func (z *ZRLEEncoding) appendPalette(f *types.PixelFormat, size int) {
+	for _, c := range z.colours {
+		z.tiles = appendCPixel(z.tiles, f, c, size)
+	}
+}
+
+// zrlePackedBits returns the bits used per pixel in a packed palette tile.
+func zrlePackedBits(paletteSize int) int {
+	switch {
+	case paletteSize <= 2:
+		return 1
+	case paletteSize <= 4:
+		return 2
+	}
+	return 4
+}
+
+// zrleRunLength returns how many pixels at the start of pixels share the first one's value.
+func zrleRunLength(pixels []uint32) int {
+	n := 1
+	for n < len(pixels) && pixels[n] == pixels[0] {
+		n++
+	}
+	return n
+}
+
+// appendZRLERunLength appends a run length as a series of 255s followed by the remainder.
+func appendZRLERunLength(dst []byte, n int) []byte {
+	n--
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
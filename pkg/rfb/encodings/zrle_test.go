@@ -0,0 +1,164 @@
+package encodings
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"testing"
+
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// zrleDecoder decodes ZRLE, counting the subencodings it has seen.
+type zrleDecoder struct {
+	stream zlibReader
+	seen   map[string]int
+}
+
+func (d *zrleDecoder) decode(r *bytes.Reader, f *types.PixelFormat, width, height int) ([]uint32, error) {
+	compressed, err := readChunk[uint32](r)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := d.stream.feed(compressed)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTiles(width, height, zrleTileSize, func(pixels []uint32, tile image.Rectangle) error {
+		out := make([]uint32, 0, tile.Dx()*tile.Dy())
+		if err := d.decodeTile(zr, f, tile.Dx(), tile.Dy(), &out); err != nil {
+			return err
+		}
+		for y := 0; y < tile.Dy(); y++ {
+			copy(pixels[(tile.Min.Y+y)*width+tile.Min.X:], out[y*tile.Dx():(y+1)*tile.Dx()])
+		}
+		return nil
+	})
+}
+
+// decodeTile appends the pixels of a tile to out.
+func (d *zrleDecoder) decodeTile(r io.Reader, f *types.PixelFormat, width, height int, out *[]uint32) error {
+	size := cpixelSize(f)
+	n := width * height
+	sub, err := readByte(r)
+	if err != nil {
+		return err
+	}
+	var palette []uint32
+	if sub >= 2 && sub <= 16 || sub >= 130 {
+		for range int(sub &^ zrlePlainRLE) {
+			c, err := readPixel(r, f, size)
+			if err != nil {
+				return err
+			}
+			palette = append(palette, c)
+		}
+	}
+
+	switch {
+	case sub == zrleRaw:
+		d.seen["raw"]++
+		for range n {
+			c, err := readPixel(r, f, size)
+			if err != nil {
+				return err
+			}
+			*out = append(*out, c)
+		}
+	case sub == zrleSolid:
+		d.seen["solid"]++
+		c, err := readPixel(r, f, size)
+		if err != nil {
+			return err
+		}
+		for range n {
+			*out = append(*out, c)
+		}
+	case sub <= 16:
+		d.seen["packed palette"]++
+		bits := zrlePackedBits(len(palette))
+		row := make([]byte, (width*bits+7)/8)
+		for range height {
+			if _, err := io.ReadFull(r, row); err != nil {
+				return err
+			}
+			for x := range width {
+				idx := row[x*bits/8] >> (8 - bits - x*bits%8) & (1<<bits - 1)
+				if int(idx) >= len(palette) {
+					return fmt.Errorf("palette index %d of %d", idx, len(palette))
+				}
+				*out = append(*out, palette[idx])
+			}
+		}
+	case sub == zrlePlainRLE:
+		d.seen["plain RLE"]++
+		for len(*out) < n {
+			c, err := readPixel(r, f, size)
+			if err != nil {
+				return err
+			}
+			if err := appendRun(r, out, c, n); err != nil {
+				return err
+			}
+		}
+	case sub >= 130:
+		d.seen["palette RLE"]++
+		for len(*out) < n {
+			idx, err := readByte(r)
+			if err != nil {
+				return err
+			}
+			if int(idx&0x7f) >= len(palette) {
+				return fmt.Errorf("palette index %d of %d", idx&0x7f, len(palette))
+			}
+			c := palette[idx&0x7f]
+			if idx&0x80 == 0 {
+				*out = append(*out, c)
+				continue
+			}
+			if err := appendRun(r, out, c, n); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("invalid subencoding %d", sub)
+	}
+	return nil
+}
+
+// appendRun reads a run length and appends that many pixels of colour c, checking the run
+// ends within the tile of n pixels.
+func appendRun(r io.Reader, out *[]uint32, c uint32, n int) error {
+	length := 1
+	for {
+		b, err := readByte(r)
+		if err != nil {
+			return err
+		}
+		length += int(b)
+		if b != 255 {
+			break
+		}
+	}
+	if len(*out)+length > n {
+		return errors.New("run past the end of the tile")
+	}
+	for range length {
+		*out = append(*out, c)
+	}
+	return nil
+}
+
+func TestZRLERoundTrip(t *testing.T) {
+	seen := map[string]int{}
+	checkRoundTrip(t, func() Encoding { return (&ZRLEEncoding{}).NewConnection() }, func() rectDecoder {
+		return (&zrleDecoder{seen: seen}).decode
+	})
+	for _, kind := range []string{"raw", "solid", "packed palette", "plain RLE", "palette RLE"} {
+		if seen[kind] == 0 {
+			t.Errorf("no %s tiles were sent", kind)
+		}
+	}
+}
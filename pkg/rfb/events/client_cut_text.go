@@ -1,8 +1,11 @@
 package events
 
 import (
+	"io"
+
 	"github.com/kamrankamilli/gsvnc/pkg/buffer"
 	"github.com/kamrankamilli/gsvnc/pkg/display"
+	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
 	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
 )
 
@@ -21,11 +24,22 @@ func (c *ClientCutText) Handle(buf *buffer.ReadWriter, d *display.Display) error
 		return err
 	}
 
-	req.Text = make([]byte, req.Length)
+	size := req.Size()
+	if size > d.MaxCutTextSize(req.IsExtended()) {
+		log.Warningf("Dropping %d bytes of clipboard data from client, over the limit", size)
+		_, err := io.CopyN(io.Discard, buf.Reader(), int64(size))
+		return err
+	}
+	req.Text = make([]byte, size)
 	if err := buf.Read(&req.Text); err != nil {
 		return err
 	}
 
+	// View-only clients may neither change nor see the clipboard. Their extended messages
+	// still go on, to record their caps and answer their requests with an empty clipboard.
+	if d.IsViewOnly() && !req.IsExtended() {
+		return nil
+	}
 	d.DispatchClientCutText(&req)
 	return nil
 }
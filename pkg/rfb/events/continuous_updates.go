@@ -0,0 +1,24 @@
+package events
+
+import (
+	"github.com/kamrankamilli/gsvnc/pkg/buffer"
+	"github.com/kamrankamilli/gsvnc/pkg/display"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// EnableContinuousUpdates handles a client turning continuous updates on or off.
+type EnableContinuousUpdates struct{}
+
+func (e *EnableContinuousUpdates) Code() uint8 { return 150 }
+
+// ServerMessages lists EndOfContinuousUpdates, which shares the code.
+func (e *EnableContinuousUpdates) ServerMessages() []uint8 { return []uint8{150} }
+
+func (e *EnableContinuousUpdates) Handle(buf *buffer.ReadWriter, d *display.Display) error {
+	var req types.EnableContinuousUpdates
+	if err := buf.ReadInto(&req); err != nil {
+		return err
+	}
+	d.EnableContinuousUpdates(&req)
+	return nil
+}
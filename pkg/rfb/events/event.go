@@ -18,6 +18,9 @@ var DefaultEvents = []Event{
 	&KeyEvent{},
 	&PointerEvent{},
 	&ClientCutText{},
+	&SetDesktopSize{},
+	&EnableContinuousUpdates{},
+	&Fence{},
 }
 
 func GetDefaults() []Event {
@@ -0,0 +1,40 @@
+package events
+
+import (
+	"fmt"
+
+	"github.com/kamrankamilli/gsvnc/pkg/buffer"
+	"github.com/kamrankamilli/gsvnc/pkg/display"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// maxFencePayload is the most data a fence may carry.
+const maxFencePayload = 64
+
+// Fence handles fence messages, answering the client's and receiving answers to ours.
+type Fence struct{}
+
+func (f *Fence) Code() uint8 { return 248 }
+
+func (f *Fence) Handle(buf *buffer.ReadWriter, d *display.Display) error {
+	var fence types.Fence
+	if err := buf.ReadPadding(3); err != nil {
+		return err
+	}
+	if err := buf.Read(&fence.Flags); err != nil {
+		return err
+	}
+	var length uint8
+	if err := buf.Read(&length); err != nil {
+		return err
+	}
+	if length > maxFencePayload {
+		return fmt.Errorf("fence payload of %d bytes is too long", length)
+	}
+	fence.Payload = make([]byte, length)
+	if err := buf.Read(&fence.Payload); err != nil {
+		return err
+	}
+	d.Fence(&fence)
+	return nil
+}
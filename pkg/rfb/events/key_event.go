@@ -21,6 +21,9 @@ func (s *KeyEvent) Handle(buf *buffer.ReadWriter, d *display.Display) error {
 	if err := buf.Read(&req.Key); err != nil {
 		return err
 	}
+	if d.IsViewOnly() {
+		return nil
+	}
 	d.DispatchKeyEvent(&req)
 	return nil
 }
@@ -15,6 +15,9 @@ func (s *PointerEvent) Handle(buf *buffer.ReadWriter, d *display.Display) error
 	if err := buf.ReadInto(&req); err != nil {
 		return err
 	}
+	if d.IsViewOnly() {
+		return nil
+	}
 	d.DispatchPointerEvent(&req)
 	return nil
 }
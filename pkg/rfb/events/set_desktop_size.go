@@ -0,0 +1,40 @@
+package events
+
+import (
+	"github.com/kamrankamilli/gsvnc/pkg/buffer"
+	"github.com/kamrankamilli/gsvnc/pkg/display"
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/types"
+)
+
+// SetDesktopSize handles a client's request to resize the desktop.
+type SetDesktopSize struct{}
+
+func (s *SetDesktopSize) Code() uint8 { return 251 }
+
+func (s *SetDesktopSize) Handle(buf *buffer.ReadWriter, d *display.Display) error {
+	var req types.SetDesktopSize
+	if err := buf.ReadPadding(1); err != nil {
+		return err
+	}
+	if err := buf.Read(&req.Width); err != nil {
+		return err
+	}
+	if err := buf.Read(&req.Height); err != nil {
+		return err
+	}
+	var numScreens uint8
+	if err := buf.Read(&numScreens); err != nil {
+		return err
+	}
+	if err := buf.ReadPadding(1); err != nil {
+		return err
+	}
+	req.Screens = make([]types.Screen, numScreens)
+	for i := range req.Screens {
+		if err := buf.ReadInto(&req.Screens[i]); err != nil {
+			return err
+		}
+	}
+	d.SetDesktopSize(&req)
+	return nil
+}
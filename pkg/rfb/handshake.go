@@ -2,9 +2,11 @@ package rfb
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"reflect"
+	"time"
 
 	"github.com/kamrankamilli/gsvnc/pkg/buffer"
 	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
@@ -23,6 +25,15 @@ func (c *Conn) doHandshake() error {
 	if authType, err = c.negotiateAuth(ver, c.buf); err != nil {
 		return err
 	}
+	access := "full control"
+	if c.IsViewOnly() {
+		access = "view-only"
+	}
+	if p := c.Principal(); p != nil {
+		log.Infof("Client %s authenticated as %s (%s)", c.remoteAddr, p.Name, access)
+	} else {
+		log.Infof("Client %s authenticated (%s)", c.remoteAddr, access)
+	}
 
 	log.Info("Reading client init")
 
@@ -61,10 +72,30 @@ const (
 	statusFailed = 1
 )
 
+// Failure reasons sent to the client.
+const (
+	reasonTooManyFailures = "Too many security failures"
+	reasonAuthFailed      = "Authentication failed"
+)
+
 // NegotiateAuth will negotiate authentication on the given connection, for the given version.
 func (c *Conn) negotiateAuth(ver string, rw *buffer.ReadWriter) (auth.Type, error) {
 	buf := new(bytes.Buffer)
 
+	source := hostOf(c.remoteAddr)
+	if wait := c.s.authLimiter.blocked(source); wait > 0 {
+		log.Warningf("Rejecting %s, blacklisted for another %s", source, wait.Round(time.Second))
+		// An empty list of security types is followed by the reason.
+		util.Write(buf, uint8(0))
+		writeReason(buf, reasonTooManyFailures)
+		rw.Dispatch(buf.Bytes())
+		return nil, errors.New(reasonTooManyFailures)
+	}
+
+	if c.preauth != nil {
+		return c.negotiatePreauthenticated(ver, rw)
+	}
+
 	log.Info("Negotiating security")
 
 	util.Write(buf, uint8(len(c.s.enabledAuthTypes)))
@@ -83,13 +114,19 @@ func (c *Conn) negotiateAuth(ver string, rw *buffer.ReadWriter) (auth.Type, erro
 	authType := c.s.GetAuth(wanted)
 	log.Info("Using security: ", reflect.TypeOf(authType).Elem().Name())
 
-	if err := authType.Negotiate(rw); err != nil {
-		log.Error("Authentication failed")
+	session := &auth.Session{}
+	if err := authType.Negotiate(rw, session); err != nil {
+		log.Errorf("Authentication failed for %s: %s", source, err)
+		time.Sleep(c.s.authLimiter.failure(source))
 		buf = new(bytes.Buffer)
 		util.Write(buf, uint32(statusFailed))
+		if ver >= versions.V8 {
+			writeReason(buf, reasonAuthFailed)
+		}
 		rw.Dispatch(buf.Bytes())
 		return nil, err
 	}
+	c.s.authLimiter.success(source)
 
 	if ver >= versions.V8 {
 		// 6.1.3. SecurityResult
@@ -98,5 +135,34 @@ func (c *Conn) negotiateAuth(ver string, rw *buffer.ReadWriter) (auth.Type, erro
 		rw.Dispatch(buf.Bytes())
 	}
 
+	c.setSession(session)
 	return authType, nil
 }
+
+// negotiatePreauthenticated offers only the None security type to a client that was already
+// authenticated, and gives it the session established beforehand.
+func (c *Conn) negotiatePreauthenticated(ver string, rw *buffer.ReadWriter) (auth.Type, error) {
+	log.Info("Client is pre-authenticated, offering no security")
+	none := &auth.None{}
+	rw.Dispatch([]byte{1, none.Code()})
+	wanted, err := rw.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if wanted != none.Code() {
+		return nil, fmt.Errorf("client wanted unsupported auth type %d", int(wanted))
+	}
+	if ver >= versions.V8 {
+		buf := new(bytes.Buffer)
+		util.Write(buf, uint32(statusOK))
+		rw.Dispatch(buf.Bytes())
+	}
+	c.setSession(c.preauth)
+	return none, nil
+}
+
+// writeReason writes a failure reason string, preceded by its length.
+func writeReason(buf io.Writer, reason string) {
+	util.Write(buf, uint32(len(reason)))
+	util.Write(buf, []byte(reason))
+}
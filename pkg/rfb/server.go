@@ -1,14 +1,21 @@
 package rfb
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"errors"
 	"net"
 	"net/http"
 	"reflect"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/websocket"
 
+	"github.com/kamrankamilli/gsvnc/pkg/display"
 	"github.com/kamrankamilli/gsvnc/pkg/display/providers"
 	"github.com/kamrankamilli/gsvnc/pkg/internal/log"
 	"github.com/kamrankamilli/gsvnc/pkg/rfb/auth"
@@ -21,9 +28,49 @@ type ServerOpts struct {
 	DisplayProvider  providers.Provider
 	Width, Height    int
 	ServerPassword   string
+	ViewOnlyPassword string
 	EnabledEncodings []encodings.Encoding
 	EnabledAuthTypes []auth.Type
 	EnabledEvents    []events.Event
+
+	// TLSConfig is used by VeNCrypt. A self-signed certificate is generated if it is nil.
+	TLSConfig *tls.Config
+	// VeNCryptSubtypes restricts and orders the offered VeNCrypt subtypes.
+	VeNCryptSubtypes []uint32
+	// RSAKey is the server key used by the RSA-AES security types. An ephemeral key is
+	// generated if it is nil.
+	RSAKey *rsa.PrivateKey
+	// CredentialVerifier checks username and password logins. Security types that need
+	// one are only offered usernames when it is set.
+	CredentialVerifier auth.CredentialVerifier
+
+	// AuthFailureThreshold is how many failed authentications from one address are allowed
+	// before it is blacklisted. Zero uses DefaultAuthFailureThreshold, negative disables it.
+	AuthFailureThreshold int
+	// AuthBlacklistTimeout is how long an address is first blacklisted for. It doubles each
+	// time the address is blacklisted again. Zero uses DefaultAuthBlacklistTimeout.
+	AuthBlacklistTimeout time.Duration
+	// AuthFailureDelay is how long a failed authentication is held before it is answered,
+	// doubling with each consecutive failure. Zero uses DefaultAuthFailureDelay, negative
+	// disables it.
+	AuthFailureDelay time.Duration
+
+	// AccessRules restrict which addresses may connect. They can be replaced at runtime
+	// with SetAccessRules.
+	AccessRules *AccessRules
+
+	// TokenValidator checks the single-use tokens websocket clients may pass in the "token"
+	// query parameter. A client with a valid token skips RFB authentication.
+	TokenValidator *auth.TokenValidator
+	// RequireToken rejects websocket clients that do not pass a token.
+	RequireToken bool
+
+	// ClipboardLimits are the largest clipboard contents accepted from clients in each
+	// format. Zero limits use display.DefaultClipboardLimits, negative ones refuse the format.
+	ClipboardLimits display.ClipboardLimits
+	// ClipboardDirection says which way the clipboard is shared between the host and
+	// clients. It is shared both ways by default.
+	ClipboardDirection display.ClipboardDirection
 }
 
 // NewServer creates a new RFB server with an initial width and height.
@@ -32,11 +79,15 @@ func NewServer(opts *ServerOpts) *Server {
 		displayProvider:  opts.DisplayProvider,
 		width:            opts.Width,
 		height:           opts.Height,
-		serverPassword:   opts.ServerPassword,
 		enabledEncodings: opts.EnabledEncodings,
 		enabledAuthTypes: opts.EnabledAuthTypes,
 		enabledEvents:    opts.EnabledEvents,
 		connections:      make(map[*Conn]struct{}),
+		authLimiter:      newAuthLimiter(opts.AuthFailureThreshold, opts.AuthBlacklistTimeout, opts.AuthFailureDelay),
+		tokenValidator:   opts.TokenValidator,
+		requireToken:     opts.RequireToken,
+		clipboardLimits:  opts.ClipboardLimits,
+		clipboard:        display.NewClipboardSync(opts.ClipboardDirection),
 	}
 
 	// Configure default events if any are empty
@@ -50,27 +101,95 @@ func NewServer(opts *ServerOpts) *Server {
 		server.enabledEvents = events.GetDefaults()
 	}
 
+	server.SetAccessRules(opts.AccessRules)
+
 	// Configure tight if enabled
 	if server.TightIsEnabled() {
 		iface := server.GetAuthByName("TightSecurity")
 		tight := iface.(*auth.TightSecurity)
 		tight.AuthGetter = server.GetAuth
-		// TODO: Configure capabilities
+		tight.Verifier = opts.CredentialVerifier
+		tight.ServerMessages, tight.ClientMessages, tight.Encodings = server.tightCapabilityCodes()
+	}
+
+	// Configure VeNCrypt if enabled
+	if iface := server.GetAuthByName("VeNCrypt"); iface != nil {
+		vencrypt := iface.(*auth.VeNCrypt)
+		vencrypt.AuthGetter = server.GetAuth
+		vencrypt.Verifier = opts.CredentialVerifier
+		vencrypt.Subtypes = opts.VeNCryptSubtypes
+		vencrypt.TLSConfig = opts.TLSConfig
+		if vencrypt.TLSConfig == nil {
+			log.Info("No TLS certificate configured, generating a self-signed certificate for VeNCrypt")
+			cfg, err := auth.NewSelfSignedTLSConfig()
+			if err != nil {
+				log.Error("Could not generate a TLS certificate, VeNCrypt will be unavailable: ", err)
+				server.disableAuthTypes(func(t auth.Type) bool { return t == iface })
+			}
+			vencrypt.TLSConfig = cfg
+		}
+		if fp := auth.CertificateFingerprint(vencrypt.TLSConfig); fp != "" {
+			log.Info("VeNCrypt certificate SHA-256 fingerprint: ", fp)
+		}
+	}
+
+	// Configure ARD if enabled
+	if iface := server.GetAuthByName("ARD"); iface != nil {
+		ard := iface.(*auth.ARD)
+		ard.Verifier = opts.CredentialVerifier
+	}
+
+	// Configure RSA-AES if enabled
+	isRSAAES := func(t auth.Type) bool {
+		_, ok := t.(interface{ RSAAESConfig() *auth.RSAAES })
+		return ok
+	}
+	rsaKey := opts.RSAKey
+	if rsaKey == nil && slices.ContainsFunc(server.enabledAuthTypes, isRSAAES) {
+		log.Info("No RSA key configured, generating an ephemeral key for RSA-AES")
+		var err error
+		if rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			log.Error("Could not generate an RSA key, RSA-AES will be unavailable: ", err)
+			server.disableAuthTypes(isRSAAES)
+		} else {
+			log.Info("RSA-AES server key fingerprint: ", auth.RSAKeyFingerprint(&rsaKey.PublicKey))
+		}
+	}
+	for _, t := range server.enabledAuthTypes {
+		if rsaAES, ok := t.(interface{ RSAAESConfig() *auth.RSAAES }); ok {
+			cfg := rsaAES.RSAAESConfig()
+			cfg.PrivateKey = rsaKey
+			cfg.Verifier = opts.CredentialVerifier
+		}
 	}
 
+	server.SetPassword(opts.ServerPassword, opts.ViewOnlyPassword)
+
 	return server
 }
 
 // Server represents an RFB server. A channel is exposed for handling incoming client
 // connections.
 type Server struct {
+	// width, height and screenID describe the desktop and its screen, guarded by connMu.
+	// resizeMu serializes resizing it.
 	width, height    int
-	serverPassword   string
+	screenID         uint32
+	resizeMu         sync.Mutex
 	displayProvider  providers.Provider
 	enabledEncodings []encodings.Encoding
 	enabledAuthTypes []auth.Type
 	enabledEvents    []events.Event
 
+	authLimiter *authLimiter
+	accessRules atomic.Pointer[AccessRules]
+
+	tokenValidator *auth.TokenValidator
+	requireToken   bool
+
+	clipboardLimits display.ClipboardLimits
+	clipboard       *display.ClipboardSync
+
 	connections map[*Conn]struct{}
 	connMu      sync.RWMutex
 }
@@ -83,20 +202,27 @@ func (s *Server) Serve(ln net.Listener) error {
 		if err != nil {
 			return err
 		}
+		if err := s.GetAccessRules().Check(c.RemoteAddr().String()); err != nil {
+			log.Warningf("Rejecting connection from %s: %s", c.RemoteAddr().String(), err)
+			c.Close()
+			continue
+		}
 		log.Info("New client connection from ", c.RemoteAddr().String())
 
 		// Create a new client connection
-		conn := s.newConn(c)
+		conn := s.newConn(c, c.RemoteAddr().String())
 
-		// Do the rfb handshake
-		if err := conn.doHandshake(); err != nil {
-			log.Error("Error during server-client handshake: ", err.Error())
-			conn.c.Close()
-			continue
-		}
+		go func() {
+			// Do the rfb handshake
+			if err := conn.doHandshake(); err != nil {
+				log.Error("Error during server-client handshake: ", err.Error())
+				conn.close()
+				return
+			}
 
-		// handle events
-		go conn.serve()
+			// handle events
+			conn.serve()
+		}()
 	}
 }
 
@@ -105,29 +231,106 @@ func (s *Server) ServeWebsockify(ln net.Listener) error {
 	srvr := &http.Server{
 		Addr:        ln.Addr().String(),
 		ReadTimeout: time.Second * 300, WriteTimeout: time.Second * 300,
-		Handler: &websocket.Server{
-			Handshake: func(cfg *websocket.Config, r *http.Request) error { return nil },
-			Handler: func(wsconn *websocket.Conn) {
-				log.Info("New websocket client connection from ", wsconn.Request().RemoteAddr)
-				wsconn.PayloadType = websocket.BinaryFrame
-				// Create a new client connection
-				conn := s.newConn(wsconn)
-
-				// Do the rfb handshake
-				if err := conn.doHandshake(); err != nil {
-					log.Error("Error during server-client handshake: ", err.Error())
-					conn.c.Close()
-					return
-				}
-
-				// handle events
-				conn.serve()
-			},
-		},
+		Handler: http.HandlerFunc(s.handleWebsockify),
 	}
 	return srvr.Serve(ln)
 }
 
+// handleWebsockify checks a websocket request's address and token before upgrading it. Each
+// request gets its own websocket server, so the session a token grants goes to the
+// connection it was given for.
+func (s *Server) handleWebsockify(w http.ResponseWriter, r *http.Request) {
+	rules := s.GetAccessRules()
+	remoteAddr := rules.clientAddr(r)
+	if err := rules.Check(remoteAddr); err != nil {
+		log.Warningf("Rejecting websocket connection from %s: %s", remoteAddr, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	source := hostOf(remoteAddr)
+	if wait := s.authLimiter.blocked(source); wait > 0 {
+		log.Warningf("Rejecting %s, blacklisted for another %s", source, wait.Round(time.Second))
+		http.Error(w, reasonTooManyFailures, http.StatusForbidden)
+		return
+	}
+	session, err := s.validateToken(r)
+	if err != nil {
+		log.Warningf("Rejecting websocket connection from %s: %s", remoteAddr, err)
+		time.Sleep(s.authLimiter.failure(source))
+		http.Error(w, reasonAuthFailed, http.StatusForbidden)
+		return
+	}
+	if session != nil {
+		s.authLimiter.success(source)
+	}
+
+	ws := &websocket.Server{
+		Handler: func(wsconn *websocket.Conn) {
+			log.Info("New websocket client connection from ", remoteAddr)
+			wsconn.PayloadType = websocket.BinaryFrame
+			// Create a new client connection
+			conn := s.newConn(wsconn, remoteAddr)
+			conn.preauth = session
+
+			// Do the rfb handshake
+			if err := conn.doHandshake(); err != nil {
+				log.Error("Error during server-client handshake: ", err.Error())
+				conn.close()
+				return
+			}
+
+			// handle events
+			conn.serve()
+		},
+	}
+	ws.ServeHTTP(w, r)
+}
+
+// validateToken checks the token passed in a websocket request, if any, and returns the
+// session it grants.
+func (s *Server) validateToken(r *http.Request) (*auth.Session, error) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		if s.requireToken {
+			return nil, errors.New("no token was provided")
+		}
+		return nil, nil
+	}
+	if s.tokenValidator == nil {
+		return nil, errors.New("tokens are not enabled")
+	}
+	return s.tokenValidator.Validate(token)
+}
+
+// GetAccessRules returns the rules currently restricting client addresses.
+func (s *Server) GetAccessRules() *AccessRules { return s.accessRules.Load() }
+
+// SetAccessRules replaces the rules restricting client addresses. They apply to new
+// connections, existing ones are left alone. A nil value allows everyone.
+func (s *Server) SetAccessRules(rules *AccessRules) {
+	if rules == nil {
+		rules = &AccessRules{}
+	}
+	s.accessRules.Store(rules)
+}
+
+// SetPassword sets the full access and view-only passwords on every enabled security type
+// that checks passwords. Clients that already authenticated are unaffected, so it can be
+// used to rotate passwords on a running server.
+func (s *Server) SetPassword(password, viewOnly string) {
+	for _, t := range s.enabledAuthTypes {
+		if setter, ok := t.(interface{ SetPassword(string, string) }); ok {
+			setter.SetPassword(password, viewOnly)
+		}
+	}
+}
+
+// disableAuthTypes stops offering the security types for which drop returns true, such as
+// those that could not be set up.
+func (s *Server) disableAuthTypes(drop func(auth.Type) bool) {
+	s.enabledAuthTypes = slices.DeleteFunc(slices.Clone(s.enabledAuthTypes), drop)
+}
+
 // AuthIsSupported returns true if the given auth type is supported.
 func (s *Server) AuthIsSupported(code uint8) bool {
 	for _, t := range s.enabledAuthTypes {
@@ -160,6 +363,26 @@ func (s *Server) TightIsEnabled() bool {
 	return false
 }
 
+// tightCapabilityCodes returns the codes of the server messages, client messages, encodings
+// and pseudo-encodings enabled on the server, for TightSecurity to advertise. Client message
+// handlers that also send server messages list them with a ServerMessages method.
+func (s *Server) tightCapabilityCodes() (serverMsgs, clientMsgs, encs []int32) {
+	for _, ev := range s.enabledEvents {
+		clientMsgs = append(clientMsgs, int32(ev.Code()))
+		if sender, ok := ev.(interface{ ServerMessages() []uint8 }); ok {
+			for _, code := range sender.ServerMessages() {
+				serverMsgs = append(serverMsgs, int32(code))
+			}
+		}
+	}
+	for _, enc := range s.enabledEncodings {
+		encs = append(encs, enc.Code())
+	}
+	encs = append(encs, encodings.SettingsPseudoEncodings(s.enabledEncodings)...)
+	encs = append(encs, display.PseudoEncodings()...)
+	return
+}
+
 // GetAuth returns the auth handler for the given code.
 func (s *Server) GetAuth(code uint8) auth.Type {
 	for _, t := range s.enabledAuthTypes {
@@ -190,10 +413,29 @@ func (s *Server) GetEventHandlerMap() map[uint8]events.Event {
 	return out
 }
 
+// encodingEnabled returns true if the given encoding is enabled on the server.
+func encodingEnabled(enabled []encodings.Encoding, code int32) bool {
+	for _, enc := range enabled {
+		if enc.Code() == code {
+			return true
+		}
+	}
+	return false
+}
+
 // GetEncoding chooses the best match from requested encodings.
 func (s *Server) GetEncoding(encs []int32) encodings.Encoding {
+	return chooseEncoding(s.enabledEncodings, encs)
+}
+
+// chooseEncoding returns the first requested encoding found in enabled, ignoring those only
+// used alongside another.
+func chooseEncoding(enabled []encodings.Encoding, encs []int32) encodings.Encoding {
 	for _, e := range encs {
-		for _, supported := range s.enabledEncodings {
+		for _, supported := range enabled {
+			if _, ok := supported.(encodings.Supplementary); ok {
+				continue
+			}
 			if e == supported.Code() {
 				log.Debugf("Using %s encoding", reflect.TypeOf(supported).Elem().Name())
 				return supported
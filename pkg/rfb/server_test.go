@@ -0,0 +1,22 @@
+package rfb
+
+import (
+	"testing"
+
+	"github.com/kamrankamilli/gsvnc/pkg/rfb/auth"
+)
+
+func TestNewServerSetsPasswords(t *testing.T) {
+	vncAuth := &auth.VNCAuth{}
+	ard := &auth.ARD{}
+	NewServer(&ServerOpts{
+		EnabledAuthTypes: []auth.Type{vncAuth, ard},
+		ServerPassword:   "secret",
+		ViewOnlyPassword: "watcher",
+	})
+	for name, p := range map[string]*auth.Passwords{"VNCAuth": &vncAuth.Passwords, "ARD": &ard.Passwords} {
+		if p.Password != "secret" || p.ViewOnlyPassword != "watcher" {
+			t.Errorf("%s passwords = %q, %q, want %q, %q", name, p.Password, p.ViewOnlyPassword, "secret", "watcher")
+		}
+	}
+}
@@ -53,3 +53,56 @@ type ClientCutText struct {
 	Length uint32
 	Text   []uint8
 }
+
+// IsExtended returns true if the message holds extended clipboard data rather than text,
+// which is flagged by a negative length.
+func (c *ClientCutText) IsExtended() bool { return int32(c.Length) < 0 }
+
+// Size returns the number of bytes of text or extended clipboard data.
+func (c *ClientCutText) Size() int {
+	if c.IsExtended() {
+		return int(-int64(int32(c.Length)))
+	}
+	return int(c.Length)
+}
+
+// Screen is one screen of the desktop, as laid out in ExtendedDesktopSize rectangles and
+// SetDesktopSize messages.
+type Screen struct {
+	ID                  uint32
+	X, Y, Width, Height uint16
+	Flags               uint32
+}
+
+// SetDesktopSize is a client's request to change the size and screen layout of the desktop.
+type SetDesktopSize struct {
+	Width, Height uint16
+	Screens       []Screen
+}
+
+// EnableContinuousUpdates is a client's request to turn continuous updates of an area on or
+// off.
+type EnableContinuousUpdates struct {
+	EnableFlag          uint8
+	X, Y, Width, Height uint16
+}
+
+// Enabled returns true if continuous updates are being turned on.
+func (e *EnableContinuousUpdates) Enabled() bool { return e.EnableFlag != 0 }
+
+// Fence is a fence message, used to synchronize with the other side and measure round trips.
+type Fence struct {
+	Flags   uint32
+	Payload []byte
+}
+
+// IsRequest returns true if the fence needs an answer.
+func (f *Fence) IsRequest() bool { return f.Flags&FenceRequest != 0 }
+
+// Fence flags.
+const (
+	FenceBlockBefore = 1 << 0
+	FenceBlockAfter  = 1 << 1
+	FenceSyncNext    = 1 << 2
+	FenceRequest     = 1 << 31
+)